@@ -0,0 +1,249 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/abner-tech/Test1/internal/data"
+	"github.com/abner-tech/Test1/internal/validator"
+)
+
+func (a *applicationDependences) createCategoryHandler(w http.ResponseWriter, r *http.Request) {
+	var incomingData struct {
+		Name     string `json:"name"`
+		Slug     string `json:"slug"`
+		ParentID *int64 `json:"parent_id"`
+	}
+
+	err := a.readJSON(w, r, &incomingData)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	//the slug is made from the name when the client does not send one
+	category := &data.Category{
+		Name:     incomingData.Name,
+		Slug:     incomingData.Slug,
+		ParentID: incomingData.ParentID,
+	}
+	if category.Slug == "" {
+		category.Slug = data.Slugify(category.Name)
+	}
+
+	v := validator.New()
+	data.ValidateCategory(v, category)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = a.categoryModel.Insert(category)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateSlug):
+			v.AddError("slug", "a category with this slug already exists")
+			a.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("parent_id", "must be an existing category")
+			a.failedValidationResponse(w, r, v.Errors)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/categories/%d", category.ID))
+
+	data := envelope{
+		"category": category,
+	}
+	err = a.writeResponse(w, r, http.StatusCreated, data, headers)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+func (a *applicationDependences) fetchCategoryByID(w http.ResponseWriter, r *http.Request) (*data.Category, error) {
+	id, err := a.readIDParam(r, "cid")
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return nil, err
+	}
+
+	category, err := a.categoryModel.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return nil, err
+	}
+	return category, nil
+}
+
+// display a category together with its direct sub categories
+func (a *applicationDependences) displayCategoryHandler(w http.ResponseWriter, r *http.Request) {
+	category, err := a.fetchCategoryByID(w, r)
+	if err != nil {
+		return
+	}
+
+	category.Children, err = a.categoryModel.GetAll(&category.ID)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data := envelope{
+		"category": category,
+	}
+	err = a.writeResponse(w, r, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// list every category, ?parent_id= only lists the children of one category
+func (a *applicationDependences) listCategoriesHandler(w http.ResponseWriter, r *http.Request) {
+	queryParameter := r.URL.Query()
+	v := validator.New()
+
+	var parentID *int64
+	if queryParameter.Has("parent_id") {
+		id := int64(a.getSingleIntigerParameter(queryParameter, "parent_id", 0, v))
+		v.Check(id > 0, "parent_id", "must be a valid category id")
+		parentID = &id
+	}
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	categories, err := a.categoryModel.GetAll(parentID)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data := envelope{
+		"categories": categories,
+	}
+	err = a.writeResponse(w, r, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+func (a *applicationDependences) updateCategoryHandler(w http.ResponseWriter, r *http.Request) {
+	category, err := a.fetchCategoryByID(w, r)
+	if err != nil {
+		return
+	}
+
+	//send parent_id 0 to move a category to the top level
+	var incomingData struct {
+		Name     *string `json:"name"`
+		Slug     *string `json:"slug"`
+		ParentID *int64  `json:"parent_id"`
+		Version  *int64  `json:"version"`
+	}
+
+	err = a.readJSON(w, r, &incomingData)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	expectedVersion, ok, err := a.readExpectedVersion(r, incomingData.Version)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+	if ok && expectedVersion != int64(category.Version) {
+		a.editConflictResponse(w, r)
+		return
+	}
+
+	if incomingData.Name != nil {
+		category.Name = *incomingData.Name
+	}
+	if incomingData.Slug != nil {
+		category.Slug = *incomingData.Slug
+	}
+	if incomingData.ParentID != nil {
+		category.ParentID = incomingData.ParentID
+		if *incomingData.ParentID == 0 {
+			category.ParentID = nil
+		}
+	}
+
+	v := validator.New()
+	data.ValidateCategory(v, category)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = a.categoryModel.Update(category)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateSlug):
+			v.AddError("slug", "a category with this slug already exists")
+			a.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("parent_id", "must be an existing category")
+			a.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrCategoryLoop):
+			v.AddError("parent_id", "must not be one of the category's own sub categories")
+			a.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			a.editConflictResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	data := envelope{
+		"category": category,
+	}
+	err = a.writeResponse(w, r, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+func (a *applicationDependences) deleteCategoryHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := a.readIDParam(r, "cid")
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	err = a.categoryModel.Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrCategoryInUse):
+			message := "the category still has sub categories or products, move them first"
+			a.errorResponseJSON(w, r, http.StatusConflict, message)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	data := envelope{
+		"message": "category deleted successfully",
+	}
+	err = a.writeResponse(w, r, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/abner-tech/Test1/internal/data"
+)
+
+// own type for context keys so they do not clash with other packages
+type contextKey string
+
+const (
+	userContextKey    = contextKey("user")
+	encoderContextKey = contextKey("encoder")
+)
+
+// return a copy of the request with the user added to its context
+func (a *applicationDependences) contextSetUser(r *http.Request, user *data.User) *http.Request {
+	ctx := context.WithValue(r.Context(), userContextKey, user)
+	return r.WithContext(ctx)
+}
+
+// get the user from the request context, every request that went through
+// authenticate() has one so a missing user means the program messed up
+func (a *applicationDependences) contextGetUser(r *http.Request) *data.User {
+	user, ok := r.Context().Value(userContextKey).(*data.User)
+	if !ok {
+		panic("missing user value in request context")
+	}
+	return user
+}
+
+// return a copy of the request with the negotiated response encoder added
+func (a *applicationDependences) contextSetEncoder(r *http.Request, encoder responseEncoder) *http.Request {
+	ctx := context.WithValue(r.Context(), encoderContextKey, encoder)
+	return r.WithContext(ctx)
+}
+
+// get the response encoder for the request, requests that did not go
+// through negotiateContent() (e.g. a panic before it) get json
+func (a *applicationDependences) contextGetEncoder(r *http.Request) responseEncoder {
+	encoder, ok := r.Context().Value(encoderContextKey).(responseEncoder)
+	if !ok {
+		return jsonEncoder{}
+	}
+	return encoder
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"mime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// turns an envelope into a response body in one format. Handlers never
+// pick one themselves, writeResponse uses the encoder negotiated from
+// the Accept header
+type responseEncoder interface {
+	//the first media type is sent as the Content-Type, the others are
+	//accepted as aliases in the Accept header
+	MediaTypes() []string
+	Encode(data envelope) ([]byte, error)
+}
+
+// the encoders the api can respond with, the first one is the default
+type encoderRegistry []responseEncoder
+
+func newEncoderRegistry(encoders ...responseEncoder) encoderRegistry {
+	return encoderRegistry(encoders)
+}
+
+// the media types clients can ask for, used in the 406 message
+func (e encoderRegistry) mediaTypes() []string {
+	types := []string{}
+	for _, encoder := range e {
+		types = append(types, encoder.MediaTypes()[0])
+	}
+	return types
+}
+
+// pick the encoder the client prefers from an Accept header such as
+// "text/csv, application/json;q=0.5". No header (or */*) means the default.
+// false is returned when nothing acceptable is registered
+func (e encoderRegistry) negotiate(accept string) (responseEncoder, bool) {
+	if len(e) == 0 {
+		return jsonEncoder{}, true
+	}
+	if strings.TrimSpace(accept) == "" {
+		return e[0], true
+	}
+
+	type acceptRange struct {
+		mediaType string
+		q         float64
+	}
+	ranges := []acceptRange{}
+	excluded := map[string]bool{}
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			q, err = strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+		}
+		if q <= 0 {
+			excluded[mediaType] = true
+			continue
+		}
+		ranges = append(ranges, acceptRange{mediaType: mediaType, q: q})
+	}
+	//highest q first, the client's order breaks ties
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].q > ranges[j].q
+	})
+
+	for _, accepted := range ranges {
+		for _, encoder := range e {
+			if excluded[encoder.MediaTypes()[0]] {
+				continue
+			}
+			if mediaTypeMatches(accepted.mediaType, encoder.MediaTypes()) {
+				return encoder, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// check a media range from the Accept header (which can use wildcards)
+// against the media types of an encoder
+func mediaTypeMatches(accepted string, mediaTypes []string) bool {
+	if accepted == "*/*" {
+		return true
+	}
+	//wildcards such as text/* only match the main media type, not aliases
+	if strings.HasSuffix(accepted, "/*") {
+		return strings.HasPrefix(mediaTypes[0], strings.TrimSuffix(accepted, "*"))
+	}
+	for _, mediaType := range mediaTypes {
+		if accepted == mediaType {
+			return true
+		}
+	}
+	return false
+}
+
+// the format every handler was written for
+type jsonEncoder struct{}
+
+func (jsonEncoder) MediaTypes() []string {
+	return []string{"application/json"}
+}
+
+func (jsonEncoder) Encode(data envelope) ([]byte, error) {
+	js, err := json.MarshalIndent(data, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+	return append(js, '\n'), nil
+}
+
+// the envelope as <response> with an element per key, list items are <item>
+type xmlEncoder struct{}
+
+func (xmlEncoder) MediaTypes() []string {
+	return []string{"application/xml", "text/xml"}
+}
+
+func (xmlEncoder) Encode(data envelope) ([]byte, error) {
+	//go through json so the struct tags decide the element names
+	js, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var buffer bytes.Buffer
+	buffer.WriteString(xml.Header)
+	enc := xml.NewEncoder(&buffer)
+	enc.Indent("", "\t")
+	err = writeXMLValue(enc, "response", js)
+	if err != nil {
+		return nil, err
+	}
+	err = enc.Flush()
+	if err != nil {
+		return nil, err
+	}
+	buffer.WriteByte('\n')
+	return buffer.Bytes(), nil
+}
+
+func writeXMLValue(enc *xml.Encoder, name string, raw json.RawMessage) error {
+	start := xml.StartElement{Name: xml.Name{Local: xmlName(name)}}
+	switch jsonKind(raw) {
+	case '{':
+		fields, err := jsonObjectFields(raw)
+		if err != nil {
+			return err
+		}
+		err = enc.EncodeToken(start)
+		if err != nil {
+			return err
+		}
+		for _, field := range fields {
+			err = writeXMLValue(enc, field.key, field.value)
+			if err != nil {
+				return err
+			}
+		}
+		return enc.EncodeToken(start.End())
+	case '[':
+		var items []json.RawMessage
+		err := json.Unmarshal(raw, &items)
+		if err != nil {
+			return err
+		}
+		err = enc.EncodeToken(start)
+		if err != nil {
+			return err
+		}
+		for _, item := range items {
+			err = writeXMLValue(enc, "item", item)
+			if err != nil {
+				return err
+			}
+		}
+		return enc.EncodeToken(start.End())
+	default:
+		return enc.EncodeElement(jsonScalar(raw), start)
+	}
+}
+
+// json keys are not always valid element names (e.g. @metadata, 5)
+func xmlName(key string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '-', r == '.':
+			return r
+		default:
+			return -1
+		}
+	}, key)
+	if name == "" || strings.ContainsRune("0123456789-.", rune(name[0])) {
+		name = "_" + name
+	}
+	return name
+}
+
+// a list as one row per item, the first list in the envelope is used
+// (paging metadata is left out). Anything else becomes a single row
+type csvEncoder struct{}
+
+func (csvEncoder) MediaTypes() []string {
+	return []string{"text/csv"}
+}
+
+func (csvEncoder) Encode(data envelope) ([]byte, error) {
+	items, err := envelopeItems(data)
+	if err != nil {
+		return nil, err
+	}
+
+	//rows can leave out empty fields, so the columns are every key seen
+	columns := []string{}
+	seen := map[string]bool{}
+	rows := []map[string]string{}
+	for _, item := range items {
+		row := map[string]string{}
+		fields := []jsonField{{key: "value", value: item}}
+		if jsonKind(item) == '{' {
+			fields, err = jsonObjectFields(item)
+			if err != nil {
+				return nil, err
+			}
+		}
+		for _, field := range fields {
+			if !seen[field.key] {
+				seen[field.key] = true
+				columns = append(columns, field.key)
+			}
+			row[field.key] = csvSafe(jsonScalar(field.value))
+		}
+		rows = append(rows, row)
+	}
+
+	var buffer bytes.Buffer
+	writer := csv.NewWriter(&buffer)
+	err = writer.Write(columns)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		record := make([]string, len(columns))
+		for i, column := range columns {
+			record[i] = row[column]
+		}
+		err = writer.Write(record)
+		if err != nil {
+			return nil, err
+		}
+	}
+	writer.Flush()
+	return buffer.Bytes(), writer.Error()
+}
+
+// a list as one json item per line, anything else as a single line
+type ndjsonEncoder struct{}
+
+func (ndjsonEncoder) MediaTypes() []string {
+	return []string{"application/x-ndjson", "application/ndjson"}
+}
+
+func (ndjsonEncoder) Encode(data envelope) ([]byte, error) {
+	items, err := envelopeItems(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var buffer bytes.Buffer
+	for _, item := range items {
+		err = json.Compact(&buffer, item)
+		if err != nil {
+			return nil, err
+		}
+		buffer.WriteByte('\n')
+	}
+	return buffer.Bytes(), nil
+}
+
+// the records of an envelope for the row based formats: the items of its
+// first list, the value when it holds a single object, otherwise the
+// envelope itself
+func envelopeItems(data envelope) ([]json.RawMessage, error) {
+	js, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	fields, err := jsonObjectFields(js)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, field := range fields {
+		if jsonKind(field.value) == '[' {
+			var items []json.RawMessage
+			err = json.Unmarshal(field.value, &items)
+			return items, err
+		}
+	}
+	if len(fields) == 1 && jsonKind(fields[0].value) == '{' {
+		return []json.RawMessage{fields[0].value}, nil
+	}
+	return []json.RawMessage{js}, nil
+}
+
+type jsonField struct {
+	key   string
+	value json.RawMessage
+}
+
+// the fields of a json object in the order they were written, which for
+// structs is the order of the struct fields
+func jsonObjectFields(raw json.RawMessage) ([]jsonField, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	token, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	if token != json.Delim('{') {
+		return nil, errors.New("expected a json object")
+	}
+
+	fields := []jsonField{}
+	for dec.More() {
+		token, err = dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		var field jsonField
+		field.key, _ = token.(string)
+		err = dec.Decode(&field.value)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// the first character of a json value: '{', '[', '"', 'n' (null) and so on
+func jsonKind(raw json.RawMessage) byte {
+	trimmed := bytes.TrimSpace(raw)
+	if len(trimmed) == 0 {
+		return 0
+	}
+	return trimmed[0]
+}
+
+// a json value as plain text: strings without quotes, null as nothing,
+// objects and lists as compact json
+func jsonScalar(raw json.RawMessage) string {
+	switch jsonKind(raw) {
+	case '"':
+		var s string
+		_ = json.Unmarshal(raw, &s)
+		return s
+	case 'n':
+		return ""
+	default:
+		var buffer bytes.Buffer
+		if json.Compact(&buffer, raw) != nil {
+			return string(raw)
+		}
+		return buffer.String()
+	}
+}
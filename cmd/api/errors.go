@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"strings"
 )
 
 func (a *applicationDependences) logError(r *http.Request, err error) {
@@ -13,7 +14,7 @@ func (a *applicationDependences) logError(r *http.Request, err error) {
 
 func (a *applicationDependences) errorResponseJSON(w http.ResponseWriter, r *http.Request, status int, message any) {
 	errorData := envelope{"error": message}
-	err := a.writeJSON(w, status, errorData, nil)
+	err := a.writeResponse(w, r, status, errorData, nil)
 	if err != nil {
 		a.logError(r, err)
 		w.WriteHeader(500)
@@ -58,3 +59,49 @@ func (a *applicationDependences) rateLimitExceededResponse(w http.ResponseWriter
 	message := "rate limit exceeded"
 	a.errorResponseJSON(w, r, http.StatusTooManyRequests, message)
 }
+
+// send an error response when the record was changed by someone else (409)
+func (a *applicationDependences) editConflictResponse(w http.ResponseWriter, r *http.Request) {
+	message := "unable to update the record due to an edit conflict, please try again"
+	a.errorResponseJSON(w, r, http.StatusConflict, message)
+}
+
+func (a *applicationDependences) invalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
+	message := "invalid authentication credentials"
+	a.errorResponseJSON(w, r, http.StatusUnauthorized, message)
+}
+
+// tell the client it has to send a (valid) bearer token
+func (a *applicationDependences) invalidAuthenticationTokenResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	message := "invalid or missing authentication token"
+	a.errorResponseJSON(w, r, http.StatusUnauthorized, message)
+}
+
+func (a *applicationDependences) authenticationRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "you must be authenticated to access this resource"
+	a.errorResponseJSON(w, r, http.StatusUnauthorized, message)
+}
+
+func (a *applicationDependences) inactiveAccountResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account must be activated to access this resource"
+	a.errorResponseJSON(w, r, http.StatusForbidden, message)
+}
+
+// send a 403 when the user does not have the permission needed
+func (a *applicationDependences) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account doesn't have the necessary permissions to access this resource"
+	a.errorResponseJSON(w, r, http.StatusForbidden, message)
+}
+
+// send a 415 when the body is in a format the endpoint does not accept
+func (a *applicationDependences) unsupportedMediaTypeResponse(w http.ResponseWriter, r *http.Request, accepted ...string) {
+	message := fmt.Sprintf("the request body must be one of: %s", strings.Join(accepted, ", "))
+	a.errorResponseJSON(w, r, http.StatusUnsupportedMediaType, message)
+}
+
+// send a 406 when the client does not accept any format we can send
+func (a *applicationDependences) notAcceptableResponse(w http.ResponseWriter, r *http.Request, offered ...string) {
+	message := fmt.Sprintf("the response can only be sent as one of: %s", strings.Join(offered, ", "))
+	a.errorResponseJSON(w, r, http.StatusNotAcceptable, message)
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/abner-tech/Test1/internal/data"
+	"github.com/abner-tech/Test1/internal/validator"
+)
+
+const (
+	exportCSV    = "csv"
+	exportNDJSON = "ndjson"
+)
+
+// rows are sent to the client in batches of this size, each batch gets
+// a fresh write deadline so long exports are not cut off by the server
+// write timeout while a stalled client still is
+const (
+	exportFlushRows     = 500
+	exportWriteDeadline = 30 * time.Second
+)
+
+var exportMediaTypes = map[string]string{
+	exportCSV:    "text/csv",
+	exportNDJSON: "application/x-ndjson",
+}
+
+// pick the export format from ?format= or else the Accept header,
+// ndjson when the client does not mind
+func exportFormat(r *http.Request, v *validator.Validator) (string, bool) {
+	if format := r.URL.Query().Get("format"); format != "" {
+		v.Check(validator.PermittedValue(format, exportCSV, exportNDJSON), "format", "must be csv or ndjson")
+		return format, true
+	}
+
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return exportNDJSON, true
+	}
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		switch mediaType {
+		case "text/csv":
+			return exportCSV, true
+		case "application/x-ndjson", "application/ndjson", "*/*", "application/*":
+			return exportNDJSON, true
+		}
+	}
+	return "", false
+}
+
+// stream the rows produced by run to the client. run calls write once per
+// record with the record (used for ndjson) and its csv cells. Nothing is
+// held in memory apart from the current batch
+func (a *applicationDependences) writeExport(w http.ResponseWriter, r *http.Request, format string, name string, columns []string,
+	run func(write func(record any, row []string) error) error) {
+	controller := http.NewResponseController(w)
+	w.Header().Set("Content-Type", exportMediaTypes[format])
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, name, format))
+
+	out := &exportWriter{w: w}
+	buffer := bufio.NewWriterSize(out, 64*1024)
+	csvWriter := csv.NewWriter(buffer)
+	jsonEncoder := json.NewEncoder(buffer)
+	rows := 0
+
+	flush := func() error {
+		err := controller.SetWriteDeadline(time.Now().Add(exportWriteDeadline))
+		if err != nil && !errors.Is(err, http.ErrNotSupported) {
+			return err
+		}
+		csvWriter.Flush()
+		err = csvWriter.Error()
+		if err != nil {
+			return err
+		}
+		err = buffer.Flush()
+		if err != nil {
+			return err
+		}
+		err = controller.Flush()
+		if errors.Is(err, http.ErrNotSupported) {
+			return nil
+		}
+		return err
+	}
+
+	write := func(record any, row []string) error {
+		var err error
+		if format == exportCSV {
+			if rows == 0 {
+				err = csvWriter.Write(columns)
+				if err != nil {
+					return err
+				}
+			}
+			err = csvWriter.Write(row)
+		} else {
+			err = jsonEncoder.Encode(record)
+		}
+		if err != nil {
+			return err
+		}
+		rows++
+		if rows%exportFlushRows == 0 {
+			return flush()
+		}
+		return nil
+	}
+
+	//the first rows can take a while so the server's write timeout must not
+	//cut the export off before the first flush gives it a new deadline
+	err := controller.SetWriteDeadline(time.Now().Add(exportWriteDeadline))
+	if err != nil && !errors.Is(err, http.ErrNotSupported) {
+		w.Header().Del("Content-Disposition")
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = run(write)
+	if err == nil && rows == 0 && format == exportCSV {
+		//an empty export still gets its header line
+		err = csvWriter.Write(columns)
+	}
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		if !out.started {
+			//nothing has reached the client yet so a proper error can be sent
+			w.Header().Del("Content-Disposition")
+			a.serverErrorResponse(w, r, err)
+			return
+		}
+		//the status line is gone, all we can do is stop and log it.
+		//the client sees a truncated file
+		a.logError(r, err)
+	}
+}
+
+// remembers whether anything has been sent to the client yet
+type exportWriter struct {
+	w       io.Writer
+	started bool
+}
+
+func (e *exportWriter) Write(p []byte) (int, error) {
+	e.started = true
+	return e.w.Write(p)
+}
+
+// spreadsheets run cells starting with these as formulas
+func csvSafe(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+func formatExportFloat(value float32) string {
+	return strconv.FormatFloat(float64(value), 'f', -1, 32)
+}
+
+var productExportColumns = []string{"id", "name", "description", "price", "category", "category_id", "image_url", "tags",
+	"average_rating", "review_count", "lowest_price_30d", "created_at", "deleted_at", "version"}
+
+func productExportRow(product *data.Product) []string {
+	deletedAt := ""
+	if product.DeletedAt != nil {
+		deletedAt = product.DeletedAt.Format(time.RFC3339)
+	}
+	return []string{
+		strconv.FormatInt(product.ID, 10),
+		csvSafe(product.Name),
+		csvSafe(product.Description),
+		formatExportFloat(product.Price),
+		csvSafe(product.Category),
+		strconv.FormatInt(product.CategoryID, 10),
+		csvSafe(product.ImageUrl),
+		csvSafe(strings.Join(product.Tags, ",")),
+		formatExportFloat(product.AverageRating),
+		strconv.FormatInt(int64(product.ReviewCount), 10),
+		formatExportFloat(product.LowestPrice30d),
+		product.CreatedAt.Format(time.RFC3339),
+		deletedAt,
+		strconv.FormatInt(int64(product.Version), 10),
+	}
+}
+
+var reviewExportColumns = []string{"id", "product_id", "user_id", "user_name", "rating", "review_text", "helpful_count", "created_at", "version"}
+
+func reviewExportRow(review *data.Review) []string {
+	return []string{
+		strconv.FormatInt(review.ID, 10),
+		strconv.FormatInt(review.ProductID, 10),
+		strconv.FormatInt(review.UserID, 10),
+		csvSafe(review.UserName),
+		strconv.FormatInt(int64(review.Rating), 10),
+		csvSafe(review.ReviewText),
+		strconv.FormatInt(int64(review.HelpfulCount), 10),
+		review.CreatedAt.Format(time.RFC3339),
+		strconv.FormatInt(int64(review.Version), 10),
+	}
+}
+
+// every product matching the same filters as GET /v1/products
+func (a *applicationDependences) exportProductsHandler(w http.ResponseWriter, r *http.Request) {
+	queryParameter := r.URL.Query()
+	v := validator.New()
+	format, ok := exportFormat(r, v)
+	if !ok {
+		a.notAcceptableResponse(w, r, exportMediaTypes[exportCSV], exportMediaTypes[exportNDJSON])
+		return
+	}
+
+	productQuery := a.readProductQuery(queryParameter, v)
+	filters := data.Filters{
+		Sorting:      a.getSingleQueryParameter(queryParameter, "sort", productDefaultSort(productQuery, false)),
+		SortSafeList: productSortSafeList,
+	}
+
+	data.ValidateProductQuery(v, productQuery)
+	data.ValidateSort(v, filters)
+	data.ValidateSearchSort(v, productQuery, filters)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	if productQuery.IncludeDeleted && !a.canSeeDeletedProducts(w, r) {
+		return
+	}
+
+	a.writeExport(w, r, format, "products", productExportColumns, func(write func(any, []string) error) error {
+		return a.productModel.ExportProducts(r.Context(), productQuery, filters, func(product *data.Product) error {
+			return write(product, productExportRow(product))
+		})
+	})
+}
+
+// every review matching the same filters as GET /v1/reviews,
+// product_id limits it to one product
+func (a *applicationDependences) exportReviewsHandler(w http.ResponseWriter, r *http.Request) {
+	queryParameter := r.URL.Query()
+	v := validator.New()
+	format, ok := exportFormat(r, v)
+	if !ok {
+		a.notAcceptableResponse(w, r, exportMediaTypes[exportCSV], exportMediaTypes[exportNDJSON])
+		return
+	}
+
+	reviewText := a.getSingleQueryParameter(queryParameter, "review_text", "")
+	userName := a.getSingleQueryParameter(queryParameter, "user_name", "")
+	productID := int64(a.getSingleIntigerParameter(queryParameter, "product_id", 0, v))
+	v.Check(productID >= 0, "product_id", "must not be negative")
+	filters := data.Filters{
+		Sorting:      a.getSingleQueryParameter(queryParameter, "sort", "id"),
+		SortSafeList: reviewSortSafeList,
+	}
+
+	data.ValidateSort(v, filters)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	a.writeExport(w, r, format, "reviews", reviewExportColumns, func(write func(any, []string) error) error {
+		return a.reviewModel.ExportReviews(r.Context(), reviewText, userName, productID, filters, func(review *data.Review) error {
+			return write(review, reviewExportRow(review))
+		})
+	})
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/url"
+	"slices"
+
+	"github.com/abner-tech/Test1/internal/data"
+	"github.com/abner-tech/Test1/internal/validator"
+)
+
+// read ?fields=id,name,price and check it against the fields of the resource
+func (a *applicationDependences) readFields(queryParameter url.Values, safeList []string, v *validator.Validator) []string {
+	fields := a.getMultipleQueryParameters(queryParameter, "fields", []string{})
+	data.ValidateFields(v, fields, safeList)
+	return fields
+}
+
+// a record sent back with only some of its fields, fields keep the order
+// of the struct whatever order they were asked for in
+type fieldSubset struct {
+	record any
+	fields []string
+}
+
+func (f fieldSubset) MarshalJSON() ([]byte, error) {
+	js, err := json.Marshal(f.record)
+	if err != nil {
+		return nil, err
+	}
+	all, err := jsonObjectFields(js)
+	if err != nil {
+		return nil, err
+	}
+
+	var buffer bytes.Buffer
+	buffer.WriteByte('{')
+	for _, field := range all {
+		if !slices.Contains(f.fields, field.key) {
+			continue
+		}
+		if buffer.Len() > 1 {
+			buffer.WriteByte(',')
+		}
+		key, _ := json.Marshal(field.key)
+		buffer.Write(key)
+		buffer.WriteByte(':')
+		buffer.Write(field.value)
+	}
+	buffer.WriteByte('}')
+	return buffer.Bytes(), nil
+}
+
+// only the requested fields of record, all of them when none were requested
+func withFields(record any, fields []string) any {
+	if len(fields) == 0 {
+		return record
+	}
+	return fieldSubset{record: record, fields: fields}
+}
+
+// withFields for every record of a list
+func listWithFields[T any](records []T, fields []string) any {
+	if len(fields) == 0 {
+		return records
+	}
+	subsets := make([]fieldSubset, len(records))
+	for i, record := range records {
+		subsets[i] = fieldSubset{record: record, fields: fields}
+	}
+	return subsets
+}
@@ -14,7 +14,7 @@ func (a *applicationDependences) healthChechHandler(w http.ResponseWriter, r *ht
 		},
 	}
 
-	err := a.writeJSON(w, http.StatusOK, data, nil)
+	err := a.writeResponse(w, r, http.StatusOK, data, nil)
 	if err != nil {
 		a.serverErrorResponse(w, r, err)
 	}
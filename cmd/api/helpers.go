@@ -9,6 +9,7 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/abner-tech/Test1/internal/validator"
 	"github.com/julienschmidt/httprouter"
@@ -16,29 +17,25 @@ import (
 
 type envelope map[string]any
 
-func (a *applicationDependences) writeJSON(w http.ResponseWriter,
+// send data in the format negotiated from the Accept header
+// (see negotiateContent), json unless the client asked for another one
+func (a *applicationDependences) writeResponse(w http.ResponseWriter, r *http.Request,
 	status int, data envelope,
 	headers http.Header) error {
-	jsResponse, err := json.MarshalIndent(data, "", "\t")
+	encoder := a.contextGetEncoder(r)
+	body, err := encoder.Encode(data)
 	if err != nil {
 		return err
 	}
-	jsResponse = append(jsResponse, '\n')
 	//aditional headers to be set
 	for key, value := range headers {
 		w.Header()[key] = value
-		//w.Header().Set(key, value[])
 	}
-	//set content type header
-	w.Header().Set("Content-Type", "application/json")
-	//explicitly set the response status code
+	w.Header().Set("Content-Type", encoder.MediaTypes()[0])
+	w.Header().Add("Vary", "Accept")
 	w.WriteHeader(status)
-	_, err = w.Write(jsResponse)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	_, err = w.Write(body)
+	return err
 }
 
 func (a *applicationDependences) readJSON(w http.ResponseWriter, r *http.Request, destination any) error {
@@ -105,6 +102,34 @@ func (a *applicationDependences) readIDParam(r *http.Request, sid string) (int64
 	return id, nil
 }
 
+// read the version the client expects to be updating. It can be sent in the
+// If-Match header (e.g. If-Match: "3") or as a version field in the body.
+// ok is false if the client sent neither
+func (a *applicationDependences) readExpectedVersion(r *http.Request, bodyVersion *int64) (version int64, ok bool, err error) {
+	if bodyVersion != nil {
+		version, ok = *bodyVersion, true
+	}
+
+	header := r.Header.Get("If-Match")
+	if header == "" || header == "*" {
+		return version, ok, nil
+	}
+
+	//etags may be weak (W/"3") and are usually quoted
+	header = strings.TrimPrefix(header, "W/")
+	header = strings.Trim(header, `"`)
+	headerVersion, err := strconv.ParseInt(header, 10, 64)
+	if err != nil || headerVersion < 1 {
+		return 0, false, errors.New("invalid If-Match header, must be the record version")
+	}
+
+	//both were given but they do not agree with each other
+	if ok && version != headerVersion {
+		return 0, false, errors.New("the If-Match header and the version field do not match")
+	}
+	return headerVersion, true, nil
+}
+
 func (a *applicationDependences) getSingleQueryParameter(queryParameter url.Values, key string, defaultValue string) string {
 	//url.values is a key:value hash map of the query parameters
 	result := queryParameter.Get(key)
@@ -114,13 +139,31 @@ func (a *applicationDependences) getSingleQueryParameter(queryParameter url.Valu
 	return result
 }
 
-// func (a *applicationDependences) getMultipleQueryParameters(queryParameter url.Values, key string, defaultValue []string) []string {
-// 	result := queryParameter.Get(key)
-// 	if result == "" {
-// 		return defaultValue
-// 	}
-// 	return strings.Split(result, ",")
-// }
+func (a *applicationDependences) getMultipleQueryParameters(queryParameter url.Values, key string, defaultValue []string) []string {
+	result := queryParameter.Get(key)
+	if result == "" {
+		return defaultValue
+	}
+	return strings.Split(result, ",")
+}
+
+// comma separated numbers e.g price_buckets=0,25,50
+func (a *applicationDependences) getMultipleFloatParameters(queryParameter url.Values, key string, defaultValue []float64, v *validator.Validator) []float64 {
+	values := a.getMultipleQueryParameters(queryParameter, key, nil)
+	if values == nil {
+		return defaultValue
+	}
+	result := []float64{}
+	for _, value := range values {
+		floatValue, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			v.AddError(key, "must only contain numbers")
+			return defaultValue
+		}
+		result = append(result, floatValue)
+	}
+	return result
+}
 
 // NOTE: this method can cause validation errors when attempting to convert from string to valid int value
 func (a *applicationDependences) getSingleIntigerParameter(queryParameter url.Values, key string, defaultValue int, v *validator.Validator) int {
@@ -136,3 +179,79 @@ func (a *applicationDependences) getSingleIntigerParameter(queryParameter url.Va
 	}
 	return intValue
 }
+
+// accepts true/false (and the other values strconv.ParseBool understands)
+func (a *applicationDependences) getSingleBoolParameter(queryParameter url.Values, key string, defaultValue bool, v *validator.Validator) bool {
+	result := queryParameter.Get(key)
+	if result == "" {
+		return defaultValue
+	}
+	boolValue, err := strconv.ParseBool(result)
+	if err != nil {
+		v.AddError(key, "must be true or false")
+		return defaultValue
+	}
+	return boolValue
+}
+
+// returns nil when the parameter was not sent so the filter can be skipped
+func (a *applicationDependences) getOptionalFloatParameter(queryParameter url.Values, key string, v *validator.Validator) *float64 {
+	result := queryParameter.Get(key)
+	if result == "" {
+		return nil
+	}
+	floatValue, err := strconv.ParseFloat(result, 64)
+	if err != nil {
+		v.AddError(key, "must be a number")
+		return nil
+	}
+	return &floatValue
+}
+
+// accepts either a plain date (2024-10-30) or a full RFC3339 timestamp
+func (a *applicationDependences) getOptionalTimeParameter(queryParameter url.Values, key string, v *validator.Validator) *time.Time {
+	result := queryParameter.Get(key)
+	if result == "" {
+		return nil
+	}
+	for _, layout := range []string{time.RFC3339, time.DateOnly} {
+		timeValue, err := time.Parse(layout, result)
+		if err == nil {
+			return &timeValue
+		}
+	}
+	v.AddError(key, "must be a date (YYYY-MM-DD) or an RFC3339 timestamp")
+	return nil
+}
+
+// same as getOptionalTimeParameter but for the end of a range, the result is
+// used as an exclusive bound. A plain date covers that whole day so it
+// becomes the start of the next day
+func (a *applicationDependences) getOptionalEndTimeParameter(queryParameter url.Values, key string, v *validator.Validator) *time.Time {
+	result := queryParameter.Get(key)
+	if result == "" {
+		return nil
+	}
+	timeValue, err := time.Parse(time.DateOnly, result)
+	if err == nil {
+		end := timeValue.AddDate(0, 0, 1)
+		return &end
+	}
+	return a.getOptionalTimeParameter(queryParameter, key, v)
+}
+
+// run a function in a goroutine that cannot crash the server,
+// shutdown waits for it to finish
+func (a *applicationDependences) background(fn func()) {
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		defer func() {
+			err := recover()
+			if err != nil {
+				a.logger.Error(fmt.Sprintf("%v", err))
+			}
+		}()
+		fn()
+	}()
+}
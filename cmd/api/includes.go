@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/url"
+
+	"github.com/abner-tech/Test1/internal/data"
+	"github.com/abner-tech/Test1/internal/validator"
+)
+
+// related resources that can be embedded in product responses
+// with ?include=reviews,rating_summary
+const (
+	includeReviews       = "reviews"
+	includeRatingSummary = "rating_summary"
+)
+
+var productIncludeSafeList = []string{includeReviews, includeRatingSummary}
+
+type productIncludes struct {
+	Reviews       bool
+	RatingSummary bool
+	ReviewFilters data.Filters //how the embedded reviews are sorted
+	ReviewsLimit  int          //most reviews embedded per product
+}
+
+// read ?include= and the parameters of the embedded reviews,
+// e.g include=reviews&reviews_limit=3&reviews_sort=-helpful_count
+func (a *applicationDependences) readProductIncludes(queryParameter url.Values, v *validator.Validator) productIncludes {
+	var includes productIncludes
+	for _, include := range a.getMultipleQueryParameters(queryParameter, "include", []string{}) {
+		v.Check(validator.PermittedValue(include, productIncludeSafeList...), "include", "must be reviews or rating_summary")
+		includes.Reviews = includes.Reviews || include == includeReviews
+		includes.RatingSummary = includes.RatingSummary || include == includeRatingSummary
+	}
+
+	includes.ReviewsLimit = a.getSingleIntigerParameter(queryParameter, "reviews_limit", 5, v)
+	v.Check(includes.ReviewsLimit >= 1 && includes.ReviewsLimit <= 50, "reviews_limit", "must be between 1 and 50")
+	includes.ReviewFilters = data.Filters{
+		Sorting:      a.getSingleQueryParameter(queryParameter, "reviews_sort", "-created_at"),
+		SortSafeList: reviewSortSafeList,
+	}
+	//the sort errors are reported under reviews_sort, sort belongs to the products
+	sortErrors := validator.New()
+	data.ValidateSort(sortErrors, includes.ReviewFilters)
+	for _, message := range sortErrors.Errors {
+		v.AddError("reviews_sort", message)
+	}
+	return includes
+}
+
+// the products as they are sent back: only the requested fields, with the
+// included resources embedded. Related resources are loaded for all the
+// products at once, one query for each kind
+func (a *applicationDependences) productResponses(products []*data.Product, fields []string, includes productIncludes) ([]any, error) {
+	ids := make([]int64, len(products))
+	for i, product := range products {
+		ids[i] = product.ID
+	}
+
+	var reviews map[int64][]*data.Review
+	var summaries map[int64]*data.RatingSummary
+	var err error
+	if includes.Reviews && len(ids) > 0 {
+		reviews, err = a.reviewModel.GetReviewsForProducts(ids, includes.ReviewFilters, includes.ReviewsLimit)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if includes.RatingSummary && len(ids) > 0 {
+		summaries, err = a.reviewModel.GetRatingSummaries(ids)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	responses := make([]any, len(products))
+	for i, product := range products {
+		record := withFields(product, fields)
+		if !includes.Reviews && !includes.RatingSummary {
+			responses[i] = record
+			continue
+		}
+		embed := withEmbedded{record: record}
+		if includes.Reviews {
+			embed.add(includeReviews, reviews[product.ID])
+		}
+		if includes.RatingSummary {
+			embed.add(includeRatingSummary, summaries[product.ID])
+		}
+		responses[i] = embed
+	}
+	return responses, nil
+}
+
+// a record with related resources added as extra fields after its own
+type withEmbedded struct {
+	record   any
+	embedded []jsonField
+	err      error
+}
+
+func (e *withEmbedded) add(key string, value any) {
+	js, err := json.Marshal(value)
+	if err != nil && e.err == nil {
+		e.err = err
+	}
+	e.embedded = append(e.embedded, jsonField{key: key, value: js})
+}
+
+func (e withEmbedded) MarshalJSON() ([]byte, error) {
+	if e.err != nil {
+		return nil, e.err
+	}
+	js, err := json.Marshal(e.record)
+	if err != nil {
+		return nil, err
+	}
+
+	//reopen the object and add the embedded fields before closing it again
+	var buffer bytes.Buffer
+	js = bytes.TrimSpace(js)
+	buffer.Write(js[:len(js)-1])
+	for i, field := range e.embedded {
+		if i > 0 || len(bytes.TrimSpace(js[1:len(js)-1])) > 0 {
+			buffer.WriteByte(',')
+		}
+		key, _ := json.Marshal(field.key)
+		buffer.Write(key)
+		buffer.WriteByte(':')
+		buffer.Write(field.value)
+	}
+	buffer.WriteByte('}')
+	return buffer.Bytes(), nil
+}
@@ -6,9 +6,11 @@ import (
 	"flag"
 	"log/slog"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/abner-tech/Test1/internal/data"
+	"github.com/abner-tech/Test1/internal/mailer"
 	_ "github.com/lib/pq"
 )
 
@@ -25,13 +27,33 @@ type serverConfig struct {
 		burst   int
 		enabled bool
 	}
+	smtp struct {
+		host     string
+		port     int
+		username string
+		password string
+		sender   string
+	}
+	mailDir string
+	purge   struct {
+		retention time.Duration
+		interval  time.Duration
+	}
 }
 
 type applicationDependences struct {
-	config       serverConfig
-	logger       *slog.Logger
-	productModel data.ProductModel
-	reviewModel  data.ReviewModel
+	config          serverConfig
+	logger          *slog.Logger
+	productModel    data.ProductModel
+	reviewModel     data.ReviewModel
+	categoryModel   data.CategoryModel
+	tagModel        data.TagModel
+	userModel       data.UserModel
+	tokenModel      data.TokenModel
+	permissionModel data.PermissionModel
+	mailer          mailer.Mailer
+	encoders        encoderRegistry
+	wg              sync.WaitGroup
 }
 
 func main() {
@@ -43,6 +65,16 @@ func main() {
 	flag.Float64Var(&settings.limiter.rps, "limiter-rps", 2, "Rate Limiter maximum requests per second")
 	flag.IntVar(&settings.limiter.burst, "limiter-burst", 5, "Rate Limiter maximum burst")
 	flag.BoolVar(&settings.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
+	//email settings, when no smtp host is given emails are written to mail-dir
+	flag.StringVar(&settings.smtp.host, "smtp-host", "", "SMTP host (leave empty to write emails to files)")
+	flag.IntVar(&settings.smtp.port, "smtp-port", 25, "SMTP port")
+	flag.StringVar(&settings.smtp.username, "smtp-username", "", "SMTP username")
+	flag.StringVar(&settings.smtp.password, "smtp-password", "", "SMTP password")
+	flag.StringVar(&settings.smtp.sender, "smtp-sender", "Product Reviews <no-reply@test1.local>", "SMTP sender")
+	flag.StringVar(&settings.mailDir, "mail-dir", "./tmp/mail", "Directory emails are written to when no SMTP host is set")
+	//soft deleted products are removed for good after the retention period
+	flag.DurationVar(&settings.purge.retention, "purge-retention", 30*24*time.Hour, "How long soft deleted products are kept (0 disables purging)")
+	flag.DurationVar(&settings.purge.interval, "purge-interval", time.Hour, "How often soft deleted products are purged")
 
 	flag.Parse()
 
@@ -60,11 +92,24 @@ func main() {
 
 	logger.Info("Database Connection Pool Established")
 
+	var mail mailer.Mailer = mailer.NewFile(settings.mailDir, settings.smtp.sender)
+	if settings.smtp.host != "" {
+		mail = mailer.NewSMTP(settings.smtp.host, settings.smtp.port, settings.smtp.username, settings.smtp.password, settings.smtp.sender)
+	}
+
 	appInstance := &applicationDependences{
-		config:       settings,
-		logger:       logger,
-		productModel: data.ProductModel{DB: db},
-		reviewModel:  data.ReviewModel{DB: db},
+		config:          settings,
+		logger:          logger,
+		productModel:    data.ProductModel{DB: db},
+		reviewModel:     data.ReviewModel{DB: db},
+		categoryModel:   data.CategoryModel{DB: db},
+		tagModel:        data.TagModel{DB: db},
+		userModel:       data.UserModel{DB: db},
+		tokenModel:      data.TokenModel{DB: db},
+		permissionModel: data.PermissionModel{DB: db},
+		mailer:          mail,
+		//json stays the default, the others are picked with the Accept header
+		encoders: newEncoderRegistry(jsonEncoder{}, xmlEncoder{}, csvEncoder{}, ndjsonEncoder{}),
 	}
 
 	// apiServer := &http.Server{
@@ -1,12 +1,16 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/abner-tech/Test1/internal/data"
+	"github.com/abner-tech/Test1/internal/validator"
 	"golang.org/x/time/rate"
 )
 
@@ -25,6 +29,20 @@ func (a *applicationDependences) recoverPanic(next http.Handler) http.Handler {
 	})
 }
 
+// pick the response format from the Accept header before the handler runs,
+// so a request is refused with a 406 before anything is changed
+func (a *applicationDependences) negotiateContent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoder, ok := a.encoders.negotiate(r.Header.Get("Accept"))
+		if !ok {
+			a.notAcceptableResponse(w, r, a.encoders.mediaTypes()...)
+			return
+		}
+		r = a.contextSetEncoder(r, encoder)
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (a *applicationDependences) rateLimiting(next http.Handler) http.Handler {
 	type client struct {
 		limiter  *rate.Limiter
@@ -69,3 +87,91 @@ func (a *applicationDependences) rateLimiting(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// find out who is making the request from the Authorization header and
+// store the user (or the anonymous user) in the request context
+func (a *applicationDependences) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		//the response depends on the Authorization header
+		w.Header().Add("Vary", "Authorization")
+
+		authorizationHeader := r.Header.Get("Authorization")
+		if authorizationHeader == "" {
+			r = a.contextSetUser(r, data.AnonymousUser)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		//expecting "Bearer <token>"
+		headerParts := strings.Split(authorizationHeader, " ")
+		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
+			a.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+		token := headerParts[1]
+
+		v := validator.New()
+		data.ValidateTokenPlaintext(v, token)
+		if !v.IsEmpty() {
+			a.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+
+		user, err := a.userModel.GetForToken(data.ScopeAuthentication, token)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				a.invalidAuthenticationTokenResponse(w, r)
+			default:
+				a.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		r = a.contextSetUser(r, user)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// the anonymous user is not allowed through
+func (a *applicationDependences) requireAuthenticatedUser(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := a.contextGetUser(r)
+		if user.IsAnonymous() {
+			a.authenticationRequiredResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// the user must be logged in and have activated their account
+func (a *applicationDependences) requireActivatedUser(next http.HandlerFunc) http.HandlerFunc {
+	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := a.contextGetUser(r)
+		if !user.Activated {
+			a.inactiveAccountResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+	return a.requireAuthenticatedUser(fn)
+}
+
+// the user must be activated and have the given permission code
+func (a *applicationDependences) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		user := a.contextGetUser(r)
+		permissions, err := a.permissionModel.GetAllForUser(user.ID)
+		if err != nil {
+			a.serverErrorResponse(w, r, err)
+			return
+		}
+		if !permissions.Include(code) {
+			a.notPermittedResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+	return a.requireActivatedUser(fn)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/abner-tech/Test1/internal/patch"
+)
+
+// apply a merge patch (application/merge-patch+json) or a json patch
+// (application/json-patch+json) body to current, the editable fields of a
+// record, and decode the patched document into destination. patched is
+// false for any other body, which the handler decodes as before.
+// When err is not nil the error response has already been sent
+func (a *applicationDependences) readPatch(w http.ResponseWriter, r *http.Request, current any, destination any) (jsonPatch patch.Patch, patched bool, err error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != patch.MergePatchType && mediaType != patch.JSONPatchType {
+		return nil, false, nil
+	}
+
+	//same limit as readJSON
+	r.Body = http.MaxBytesReader(w, r.Body, 256_000)
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			err = fmt.Errorf("the body must not be larger than %d bytes", maxBytesError.Limit)
+		}
+		a.badRequestResponse(w, r, err)
+		return nil, true, err
+	}
+
+	document, err := json.Marshal(current)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return nil, true, err
+	}
+
+	if mediaType == patch.MergePatchType {
+		document, err = patch.Merge(document, body)
+	} else {
+		jsonPatch, err = patch.Decode(body)
+		if err == nil {
+			document, err = jsonPatch.Apply(document)
+		}
+	}
+	if err != nil {
+		var operationError *patch.OperationError
+		switch {
+		case errors.As(err, &operationError) && errors.Is(err, patch.ErrTestFailed):
+			//the record is not in the state the client expected
+			a.errorResponseJSON(w, r, http.StatusConflict, patchOperationErrors(operationError))
+		case errors.As(err, &operationError):
+			a.failedValidationResponse(w, r, patchOperationErrors(operationError))
+		default:
+			a.badRequestResponse(w, r, fmt.Errorf("the body contains an invalid patch: %w", err))
+		}
+		return nil, true, err
+	}
+
+	//the patched record must still have the right fields and types
+	dec := json.NewDecoder(bytes.NewReader(document))
+	dec.DisallowUnknownFields()
+	err = dec.Decode(destination)
+	if err != nil {
+		var unmarshalTypeError *json.UnmarshalTypeError
+		var field, message string
+		switch {
+		case errors.As(err, &unmarshalTypeError):
+			field, message = unmarshalTypeError.Field, fmt.Sprintf("must be a json %s", jsonTypeName(unmarshalTypeError.Type.Kind().String()))
+		case strings.HasPrefix(err.Error(), "json: unknown field "):
+			field, message = strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`), "is not a field that can be changed"
+		default:
+			a.badRequestResponse(w, r, err)
+			return nil, true, err
+		}
+		a.failedValidationResponse(w, r, patchErrors(jsonPatch, map[string]string{field: message}, nil))
+		return nil, true, err
+	}
+
+	return jsonPatch, true, nil
+}
+
+func patchOperationErrors(err *patch.OperationError) map[string]string {
+	return map[string]string{
+		fmt.Sprintf("patch[%d]", err.Index): fmt.Sprintf("%s %s: %v", err.Op, err.Path, err.Err),
+	}
+}
+
+// point the errors of a patched record at the json patch operation that
+// last changed the field, aliases lists other members a field is set
+// through (e.g. category through category_id). Errors of a merge patch
+// (jsonPatch is nil) keep their field names
+func patchErrors(jsonPatch patch.Patch, errors map[string]string, aliases map[string]string) map[string]string {
+	if jsonPatch == nil {
+		return errors
+	}
+	result := make(map[string]string, len(errors))
+	for field, message := range errors {
+		i := jsonPatch.LastOperationOn(field)
+		if alias, ok := aliases[field]; ok && i < 0 {
+			i = jsonPatch.LastOperationOn(alias)
+		}
+		if i < 0 {
+			result[field] = message
+			continue
+		}
+		key := fmt.Sprintf("patch[%d]", i)
+		if _, exists := result[key]; !exists {
+			result[key] = fmt.Sprintf("%s %s", field, message)
+		}
+	}
+	return result
+}
+
+// the json name of a go kind
+func jsonTypeName(kind string) string {
+	switch {
+	case strings.HasPrefix(kind, "int"), strings.HasPrefix(kind, "uint"), strings.HasPrefix(kind, "float"):
+		return "number"
+	case kind == "slice":
+		return "array"
+	case kind == "struct", kind == "map":
+		return "object"
+	case kind == "bool":
+		return "boolean"
+	}
+	return kind
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/abner-tech/Test1/internal/validator"
+)
+
+func (a *applicationDependences) listPriceHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	//optional date range e.g ?from=2024-10-01&to=2024-10-31
+	queryParameter := r.URL.Query()
+	v := validator.New()
+	from := a.getOptionalTimeParameter(queryParameter, "from", v)
+	to := a.getOptionalEndTimeParameter(queryParameter, "to", v)
+	if from != nil && to != nil {
+		v.Check(!from.After(*to), "from", "must not be later than to")
+	}
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	id := a.productIdExist(w, r)
+	if id <= 0 {
+		//error was already printed in productIdExist()
+		return
+	}
+
+	changes, err := a.productModel.GetPriceHistory(id, from, to)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data := envelope{
+		"price_history": changes,
+	}
+	err = a.writeResponse(w, r, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 
 	"github.com/abner-tech/Test1/internal/data"
 	"github.com/abner-tech/Test1/internal/validator"
@@ -13,12 +14,14 @@ func (a *applicationDependences) createProductHandler(w http.ResponseWriter, r *
 	//create a struct to hold a comment
 	//we use struct tags [` `] to make the names display in lowercase
 	var incomingData struct {
-		Name          string  `json:"name"`
-		Description   string  `json:"description"`
-		Price         float32 `json:"price"`
-		Category      string  `json:"category"`
-		ImageUrl      string  `json:"image_url"`
-		AverageRating float32 `json:"average_rating"`
+		Name          string   `json:"name"`
+		Description   string   `json:"description"`
+		Price         float32  `json:"price"`
+		Category      string   `json:"category"`
+		CategoryID    *int64   `json:"category_id"`
+		ImageUrl      string   `json:"image_url"`
+		Tags          []string `json:"tags"`
+		AverageRating float32  `json:"average_rating"`
 	}
 
 	//perform decoding
@@ -35,12 +38,20 @@ func (a *applicationDependences) createProductHandler(w http.ResponseWriter, r *
 		Price:         incomingData.Price,
 		Category:      incomingData.Category,
 		ImageUrl:      incomingData.ImageUrl,
+		Tags:          data.NormalizeTags(incomingData.Tags),
 		AverageRating: incomingData.AverageRating,
 	}
 
 	v := validator.New()
+	//the category can be given by id, slug or name
+	err = a.resolveProductCategory(v, product, incomingData.CategoryID, &incomingData.Category)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
 	//do validation
 	data.ValidateProduct(v, product)
+	data.ValidateProductCategory(v, product)
 	if !v.IsEmpty() {
 		a.failedValidationResponse(w, r, v.Errors) //implemented later
 		return
@@ -64,14 +75,14 @@ func (a *applicationDependences) createProductHandler(w http.ResponseWriter, r *
 	data := envelope{
 		"product": product,
 	}
-	err = a.writeJSON(w, http.StatusCreated, data, headers)
+	err = a.writeResponse(w, r, http.StatusCreated, data, headers)
 	if err != nil {
 		a.serverErrorResponse(w, r, err)
 		return
 	}
 }
 
-func (a *applicationDependences) fetchProductByID(w http.ResponseWriter, r *http.Request) (*data.Product, error) {
+func (a *applicationDependences) fetchProductByID(w http.ResponseWriter, r *http.Request, includeDeleted bool) (*data.Product, error) {
 	// Get the id from the URL /v1/comments/:id so that we
 	// can use it to query the comments table. We will
 	// implement the readIDParam() function later
@@ -82,7 +93,7 @@ func (a *applicationDependences) fetchProductByID(w http.ResponseWriter, r *http
 	}
 
 	// Call Get() to retrieve the comment with the specified id
-	product, err := a.productModel.GetProduct(id)
+	product, err := a.productModel.GetProduct(id, includeDeleted)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -90,31 +101,81 @@ func (a *applicationDependences) fetchProductByID(w http.ResponseWriter, r *http
 		default:
 			a.serverErrorResponse(w, r, err)
 		}
-
+		return nil, err
 	}
 	return product, nil
 }
 
 func (a *applicationDependences) displayProductHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	includeDeleted := a.getSingleBoolParameter(r.URL.Query(), "include_deleted", false, v)
+	//e.g ?fields=id,name,price,image_url
+	fields := a.readFields(r.URL.Query(), data.ProductFieldSafeList, v)
+	//e.g ?include=reviews,rating_summary&reviews_limit=3
+	includes := a.readProductIncludes(r.URL.Query(), v)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	if includeDeleted && !a.canSeeDeletedProducts(w, r) {
+		//error was already printed in canSeeDeletedProducts()
+		return
+	}
 
-	product, err := a.fetchProductByID(w, r)
+	product, err := a.fetchProductByID(w, r, includeDeleted)
+	if err != nil {
+		return
+	}
+	responses, err := a.productResponses([]*data.Product{product}, fields, includes)
 	if err != nil {
+		a.serverErrorResponse(w, r, err)
 		return
 	}
+
 	// display the comment
 	data := envelope{
-		"product": product,
+		"product": responses[0],
 	}
-	err = a.writeJSON(w, http.StatusOK, data, nil)
+	err = a.writeResponse(w, r, http.StatusOK, data, nil)
 	if err != nil {
 		a.serverErrorResponse(w, r, err)
 		return
 	}
 }
 
+// the fields of a product a merge patch or json patch can change
+type productPatchDocument struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Price       float32  `json:"price"`
+	Category    string   `json:"category"`
+	CategoryID  int64    `json:"category_id"`
+	ImageUrl    string   `json:"image_url"`
+	Tags        []string `json:"tags"`
+	Version     int64    `json:"version"` //changing it says which version is being edited
+}
+
+func newProductPatchDocument(product *data.Product) productPatchDocument {
+	//so json patches can add to the tags of a product without any
+	tags := product.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	return productPatchDocument{
+		Name:        product.Name,
+		Description: product.Description,
+		Price:       product.Price,
+		Category:    product.Category,
+		CategoryID:  product.CategoryID,
+		ImageUrl:    product.ImageUrl,
+		Tags:        tags,
+		Version:     int64(product.Version),
+	}
+}
+
 func (a *applicationDependences) updateProductHandler(w http.ResponseWriter, r *http.Request) {
 
-	product, err := a.fetchProductByID(w, r)
+	product, err := a.fetchProductByID(w, r, false)
 	if err != nil {
 		//error has been printed at fetchProductByID so we just return
 		return
@@ -125,19 +186,59 @@ func (a *applicationDependences) updateProductHandler(w http.ResponseWriter, r *
 	// between the client leaving a field empty intentionally
 	// and the field not needing to be updated
 	var incomingData struct {
-		Name        *string  `json:"name"`
-		Description *string  `json:"description"`
-		Price       *float32 `json:"price"`
-		Category    *string  `json:"category"`
-		ImageUrl    *string  `json:"image_url"`
+		Name        *string   `json:"name"`
+		Description *string   `json:"description"`
+		Price       *float32  `json:"price"`
+		Category    *string   `json:"category"`
+		CategoryID  *int64    `json:"category_id"`
+		ImageUrl    *string   `json:"image_url"`
+		Tags        *[]string `json:"tags"` //replaces every tag of the product
+		Version     *int64    `json:"version"`
+	}
+
+	// a merge patch or json patch is applied to the current product, every
+	// field it ends up with is an update so fields can also be cleared
+	var document productPatchDocument
+	jsonPatch, patched, err := a.readPatch(w, r, newProductPatchDocument(product), &document)
+	if err != nil {
+		//error was already printed in readPatch()
+		return
+	}
+	if patched {
+		incomingData.Name = &document.Name
+		incomingData.Description = &document.Description
+		incomingData.Price = &document.Price
+		incomingData.ImageUrl = &document.ImageUrl
+		incomingData.Tags = &document.Tags
+		//only look the category up again when the patch changed it
+		if document.CategoryID != product.CategoryID {
+			incomingData.CategoryID = &document.CategoryID
+		} else if document.Category != product.Category {
+			incomingData.Category = &document.Category
+		}
+		if document.Version != int64(product.Version) {
+			incomingData.Version = &document.Version
+		}
+	} else {
+		// perform the decoding
+		err = a.readJSON(w, r, &incomingData)
+		if err != nil {
+			a.badRequestResponse(w, r, err)
+			return
+		}
 	}
 
-	// perform the decoding
-	err = a.readJSON(w, r, &incomingData)
+	// if the client told us which version it is editing, it must still
+	// be the current one
+	expectedVersion, ok, err := a.readExpectedVersion(r, incomingData.Version)
 	if err != nil {
 		a.badRequestResponse(w, r, err)
 		return
 	}
+	if ok && expectedVersion != int64(product.Version) {
+		a.editConflictResponse(w, r)
+		return
+	}
 	// We need to now check the fields to see which ones need updating
 	// if incomingData.Name is nil, no update was provided
 	if incomingData.Name != nil {
@@ -149,31 +250,46 @@ func (a *applicationDependences) updateProductHandler(w http.ResponseWriter, r *
 	if incomingData.Price != nil {
 		product.Price = *incomingData.Price
 	}
-	if incomingData.Category != nil {
-		product.Category = *incomingData.Category
-	}
 	if incomingData.ImageUrl != nil {
 		product.ImageUrl = *incomingData.ImageUrl
 	}
+	if incomingData.Tags != nil {
+		product.Tags = data.NormalizeTags(*incomingData.Tags)
+	}
 
-	// Before we write the updates to the DB let's validate
+	// Before we write the updates to the DB let's validate. The category is
+	// only checked when it changes so older products without a category
+	// row can still be updated
 	v := validator.New()
+	if incomingData.Category != nil || incomingData.CategoryID != nil {
+		err = a.resolveProductCategory(v, product, incomingData.CategoryID, incomingData.Category)
+		if err != nil {
+			a.serverErrorResponse(w, r, err)
+			return
+		}
+		data.ValidateProductCategory(v, product)
+	}
 	data.ValidateProduct(v, product)
 	if !v.IsEmpty() {
-		a.failedValidationResponse(w, r, v.Errors)
+		a.failedValidationResponse(w, r, patchErrors(jsonPatch, v.Errors, map[string]string{"category": "category_id"}))
 		return
 	}
 
 	// perform the update
 	err = a.productModel.UpdateProducts(product)
 	if err != nil {
-		a.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			a.editConflictResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 	data := envelope{
 		"product": product,
 	}
-	err = a.writeJSON(w, http.StatusOK, data, nil)
+	err = a.writeResponse(w, r, http.StatusOK, data, nil)
 	if err != nil {
 		a.serverErrorResponse(w, r, err)
 		return
@@ -199,47 +315,95 @@ func (a *applicationDependences) deleteProductHandler(w http.ResponseWriter, r *
 
 	//diisplay the product
 	data := envelope{
-		"message": "product deleted successfully",
+		"message": "product deleted successfully, it can be restored until it is purged",
 	}
-	err = a.writeJSON(w, http.StatusOK, data, nil)
+	err = a.writeResponse(w, r, http.StatusOK, data, nil)
 	if err != nil {
 		a.serverErrorResponse(w, r, err)
 	}
 }
 
+// the columns products can be sorted by
+var productSortSafeList = []string{"id", "name", "price", "average_rating", "created_at", "category",
+	"-id", "-name", "-price", "-average_rating", "-created_at", "-category", "relevance", "-relevance"}
+
+// best matches first when searching, unless the client picks another order.
+// relevance can not be used as a cursor so cursor paging keeps id
+func productDefaultSort(query data.ProductQuery, useCursor bool) string {
+	if query.Search != "" && !useCursor {
+		return "-relevance"
+	}
+	return "id"
+}
+
+// read the product filters shared by the list and export endpoints
+func (a *applicationDependences) readProductQuery(queryParameter url.Values, v *validator.Validator) data.ProductQuery {
+	var query data.ProductQuery
+	query.Category = a.getSingleQueryParameter(queryParameter, "category", "")
+	query.Name = a.getSingleQueryParameter(queryParameter, "name", "")
+	query.Description = a.getSingleQueryParameter(queryParameter, "description", "")
+	//ranked search over name, category and description e.g q=wireless keyboard -mouse
+	query.Search = a.getSingleQueryParameter(queryParameter, "q", "")
+	query.SearchConfig = a.getSingleQueryParameter(queryParameter, "search_config", data.DefaultSearchConfig)
+
+	//range filters
+	query.MinPrice = a.getOptionalFloatParameter(queryParameter, "min_price", v)
+	query.MaxPrice = a.getOptionalFloatParameter(queryParameter, "max_price", v)
+	query.MinRating = a.getOptionalFloatParameter(queryParameter, "min_rating", v)
+	query.CreatedAfter = a.getOptionalTimeParameter(queryParameter, "created_after", v)
+	query.CreatedBefore = a.getOptionalEndTimeParameter(queryParameter, "created_before", v)
+	query.IncludeDeleted = a.getSingleBoolParameter(queryParameter, "include_deleted", false, v)
+	//e.g tags=rgb,wireless&tag_match=all
+	query.Tags = data.NormalizeTags(a.getMultipleQueryParameters(queryParameter, "tags", []string{}))
+	query.TagMatch = a.getSingleQueryParameter(queryParameter, "tag_match", data.TagMatchAny)
+
+	return query
+}
+
 func (a *applicationDependences) listProductHandler(w http.ResponseWriter, r *http.Request) {
 	//create a struct to hold the query parameters
 	//Later, fields will be added for pagination and sorting (filters)
 	var queryParameterData struct {
-		Category    string
-		Name        string
-		Description string
+		data.ProductQuery
 		data.Filters
+		data.FacetRequest
 	}
 
 	//get query parameters from url
 	queryParameter := r.URL.Query()
 
-	//load the query parameters into the created struct
-	queryParameterData.Category = a.getSingleQueryParameter(queryParameter, "category", "")
-	queryParameterData.Name = a.getSingleQueryParameter(queryParameter, "name", "")
-	queryParameterData.Description = a.getSingleQueryParameter(queryParameter, "description", "")
 	v := validator.New()
+	queryParameterData.ProductQuery = a.readProductQuery(queryParameter, v)
+
+	//optional facet counts e.g facets=category,price&price_buckets=0,20,50
+	queryParameterData.Facets = a.getMultipleQueryParameters(queryParameter, "facets", []string{})
+	queryParameterData.PriceBuckets = a.getMultipleFloatParameters(queryParameter, "price_buckets", data.DefaultPriceBuckets, v)
 
 	queryParameterData.Filters.Page = a.getSingleIntigerParameter(queryParameter, "page", 1, v)
 	queryParameterData.Filters.PageSize = a.getSingleIntigerParameter(queryParameter, "page_size", 10, v)
-	queryParameterData.Filters.Sorting = a.getSingleQueryParameter(queryParameter, "sorting", "id")
-	queryParameterData.Filters.SortSafeList = []string{"id", "id", "-id", "-id"}
+	//sending cursor (empty for the first page) switches to cursor paging
+	queryParameterData.Filters.UseCursor = queryParameter.Has("cursor")
+	queryParameterData.Filters.Sorting = a.getSingleQueryParameter(queryParameter, "sort", productDefaultSort(queryParameterData.ProductQuery, queryParameterData.Filters.UseCursor))
+	queryParameterData.Filters.Cursor = queryParameter.Get("cursor")
+	queryParameterData.Filters.SortSafeList = productSortSafeList
+	queryParameterData.Filters.Fields = a.readFields(queryParameter, data.ProductFieldSafeList, v)
+	includes := a.readProductIncludes(queryParameter, v)
 
 	//check validity of filters
+	data.ValidateProductQuery(v, queryParameterData.ProductQuery)
 	data.ValidateFilters(v, queryParameterData.Filters)
+	data.ValidateSearchSort(v, queryParameterData.ProductQuery, queryParameterData.Filters)
+	data.ValidateFacetRequest(v, queryParameterData.FacetRequest)
 	if !v.IsEmpty() {
 		a.failedValidationResponse(w, r, v.Errors)
 		return
 	}
+	if queryParameterData.IncludeDeleted && !a.canSeeDeletedProducts(w, r) {
+		return
+	}
 
 	//call GetAll to retrieve all comments of the DB
-	products, metadata, err := a.productModel.GetAllProducts(queryParameterData.Category, queryParameterData.Name, queryParameterData.Description, queryParameterData.Filters)
+	products, metadata, err := a.productModel.GetAllProducts(queryParameterData.ProductQuery, queryParameterData.Filters)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -251,11 +415,29 @@ func (a *applicationDependences) listProductHandler(w http.ResponseWriter, r *ht
 		}
 	}
 
+	//embedded reviews and summaries are fetched for the whole page at once
+	responses, err := a.productResponses(products, queryParameterData.Filters.Fields, includes)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
 	data := envelope{
-		"products":  products,
+		"products":  responses,
 		"@metadata": metadata,
 	}
-	err = a.writeJSON(w, http.StatusOK, data, nil)
+
+	//facets are only counted when asked for
+	if len(queryParameterData.Facets) > 0 {
+		facets, err := a.productModel.GetProductFacets(queryParameterData.ProductQuery, queryParameterData.FacetRequest)
+		if err != nil {
+			a.serverErrorResponse(w, r, err)
+			return
+		}
+		data["facets"] = facets
+	}
+
+	err = a.writeResponse(w, r, http.StatusOK, data, nil)
 	if err != nil {
 		a.serverErrorResponse(w, r, err)
 		return
@@ -285,3 +467,99 @@ func (a *applicationDependences) productIdExist(w http.ResponseWriter, r *http.R
 	}
 	return id
 }
+
+// only users who manage the catalog can see soft deleted products,
+// sends the error response and returns false for everybody else
+func (a *applicationDependences) canSeeDeletedProducts(w http.ResponseWriter, r *http.Request) bool {
+	user := a.contextGetUser(r)
+	if user.IsAnonymous() {
+		a.authenticationRequiredResponse(w, r)
+		return false
+	}
+
+	permissions, err := a.permissionModel.GetAllForUser(user.ID)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return false
+	}
+	if !permissions.Include(data.PermissionProductsWrite) {
+		a.notPermittedResponse(w, r)
+		return false
+	}
+	return true
+}
+
+func (a *applicationDependences) restoreProductHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := a.readIDParam(r, "pid")
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	//not found also covers products that were never deleted
+	product, err := a.productModel.RestoreProduct(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	data := envelope{
+		"product": product,
+	}
+	err = a.writeResponse(w, r, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// point the product at the category the client asked for, by id or by slug/name.
+// unknown categories are reported through the validator
+func (a *applicationDependences) resolveProductCategory(v *validator.Validator, product *data.Product, categoryID *int64, category *string) error {
+	var found *data.Category
+	var err error
+	switch {
+	case categoryID != nil:
+		found, err = a.categoryModel.Get(*categoryID)
+	case category != nil && *category != "":
+		found, err = a.categoryModel.Resolve(*category)
+	default:
+		v.AddError("category", "must be provided")
+		return nil
+	}
+
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("category", "must be an existing category")
+			return nil
+		default:
+			return err
+		}
+	}
+
+	product.CategoryID = found.ID
+	product.Category = found.Name
+	return nil
+}
+
+// list every tag with how many products use it
+func (a *applicationDependences) listTagsHandler(w http.ResponseWriter, r *http.Request) {
+	tags, err := a.tagModel.GetAllWithCounts()
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data := envelope{
+		"tags": tags,
+	}
+	err = a.writeResponse(w, r, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/abner-tech/Test1/internal/data"
+	"github.com/abner-tech/Test1/internal/validator"
+)
+
+// imports are much bigger than the 256KB readJSON allows
+const maxImportBytes = 32 << 20
+
+// how long a client has to send the whole import
+const importReadTimeout = 2 * time.Minute
+
+// how long saving the rows and answering may take after that
+const importWriteTimeout = 2 * time.Minute
+
+// one product as it appears in an import file
+type importProduct struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Price       float32  `json:"price"`
+	Category    string   `json:"category"`
+	CategoryID  *int64   `json:"category_id"`
+	ImageUrl    string   `json:"image_url"`
+	Tags        []string `json:"tags"`
+}
+
+// a parsed row of an import file, line is the line it starts on
+type importRecord struct {
+	line    int
+	product importProduct
+	errors  map[string]string //problems found while parsing the row
+}
+
+// what happened to one row of an import
+type importRowResult struct {
+	Row    int               `json:"row"`
+	Status string            `json:"status"` //created, failed or skipped
+	ID     int64             `json:"id,omitempty"`
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+func (a *applicationDependences) importProductsHandler(w http.ResponseWriter, r *http.Request) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "text/csv" && mediaType != "application/x-ndjson" {
+		a.unsupportedMediaTypeResponse(w, r, "text/csv", "application/x-ndjson")
+		return
+	}
+
+	//?mode=best_effort saves the valid rows even when others fail
+	v := validator.New()
+	mode := a.getSingleQueryParameter(r.URL.Query(), "mode", data.ImportModeAtomic)
+	v.Check(validator.PermittedValue(mode, data.ImportModeSafeList...), "mode", "must be atomic or best_effort")
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	//the server timeouts are meant for small json bodies, give uploads
+	//and saving thousands of rows longer
+	controller := http.NewResponseController(w)
+	err := controller.SetReadDeadline(time.Now().Add(importReadTimeout))
+	if err == nil {
+		err = controller.SetWriteDeadline(time.Now().Add(importReadTimeout + importWriteTimeout))
+	}
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportBytes)
+	var records []importRecord
+	if mediaType == "text/csv" {
+		records, err = readImportCSV(r.Body)
+	} else {
+		records, err = readImportNDJSON(r.Body)
+	}
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			err = fmt.Errorf("the body must not be larger than %d bytes", maxBytesError.Limit)
+		}
+		a.badRequestResponse(w, r, err)
+		return
+	}
+	if len(records) == 0 {
+		a.badRequestResponse(w, r, errors.New("the body must contain at least one product"))
+		return
+	}
+
+	//check every row before anything is saved
+	results := make([]importRowResult, len(records))
+	products := make([]*data.Product, len(records))
+	categories := map[string]*data.Category{}
+	failed := 0
+	for i, record := range records {
+		results[i].Row = record.line
+		product, rowErrors, err := a.importRowProduct(record, categories)
+		if err != nil {
+			a.serverErrorResponse(w, r, err)
+			return
+		}
+		if rowErrors != nil {
+			results[i].Status = "failed"
+			results[i].Errors = rowErrors
+			failed++
+			continue
+		}
+		products[i] = product
+	}
+
+	switch mode {
+	case data.ImportModeAtomic:
+		a.importAtomic(w, r, products, results, failed)
+	default:
+		a.importBestEffort(w, r, products, results, failed)
+	}
+}
+
+// save every product in one transaction, or none of them if a row is invalid
+func (a *applicationDependences) importAtomic(w http.ResponseWriter, r *http.Request, products []*data.Product, results []importRowResult, failed int) {
+	if failed == 0 {
+		err := a.productModel.ImportProducts(products)
+		var rowError *data.ImportRowError
+		switch {
+		case errors.As(err, &rowError):
+			a.logError(r, err)
+			results[rowError.Index].Status = "failed"
+			results[rowError.Index].Errors = map[string]string{"row": "could not be saved"}
+			failed = 1
+		case err != nil:
+			a.serverErrorResponse(w, r, err)
+			return
+		default:
+			for i, product := range products {
+				results[i].Status = "created"
+				results[i].ID = product.ID
+			}
+			a.writeImportResponse(w, r, http.StatusCreated, data.ImportModeAtomic, results, len(products), 0)
+			return
+		}
+	}
+
+	//nothing was saved
+	for i := range results {
+		if results[i].Status == "" {
+			results[i].Status = "skipped"
+		}
+	}
+	a.writeImportResponse(w, r, http.StatusUnprocessableEntity, data.ImportModeAtomic, results, 0, failed)
+}
+
+// save every valid product on its own, a failing row does not stop the rest
+func (a *applicationDependences) importBestEffort(w http.ResponseWriter, r *http.Request, products []*data.Product, results []importRowResult, failed int) {
+	created := 0
+	for i, product := range products {
+		if product == nil {
+			continue
+		}
+		err := a.productModel.InsertProduct(product)
+		if err != nil {
+			a.logError(r, err)
+			results[i].Status = "failed"
+			results[i].Errors = map[string]string{"row": "could not be saved"}
+			failed++
+			continue
+		}
+		results[i].Status = "created"
+		results[i].ID = product.ID
+		created++
+	}
+	a.writeImportResponse(w, r, http.StatusOK, data.ImportModeBestEffort, results, created, failed)
+}
+
+func (a *applicationDependences) writeImportResponse(w http.ResponseWriter, r *http.Request, status int, mode string, results []importRowResult, created int, failed int) {
+	data := envelope{
+		"import": envelope{
+			"mode":     mode,
+			"total":    len(results),
+			"imported": created,
+			"failed":   failed,
+			"rows":     results,
+		},
+	}
+	err := a.writeResponse(w, r, status, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// turn a parsed row into a product and validate it the same way a single
+// POST /v1/products is. categories caches lookups across the rows
+func (a *applicationDependences) importRowProduct(record importRecord, categories map[string]*data.Category) (*data.Product, map[string]string, error) {
+	if record.errors != nil {
+		return nil, record.errors, nil
+	}
+
+	product := &data.Product{
+		Name:        record.product.Name,
+		Description: record.product.Description,
+		Price:       record.product.Price,
+		Category:    record.product.Category,
+		ImageUrl:    record.product.ImageUrl,
+		Tags:        data.NormalizeTags(record.product.Tags),
+	}
+
+	v := validator.New()
+	key := "name:" + strings.ToLower(strings.TrimSpace(record.product.Category))
+	if record.product.CategoryID != nil {
+		key = fmt.Sprintf("id:%d", *record.product.CategoryID)
+	}
+	category, ok := categories[key]
+	if record.product.CategoryID == nil && strings.TrimSpace(record.product.Category) == "" {
+		//nothing to look up, let resolveProductCategory report it
+		ok = false
+	}
+	if !ok {
+		err := a.resolveProductCategory(v, product, record.product.CategoryID, &record.product.Category)
+		if err != nil {
+			return nil, nil, err
+		}
+		if product.CategoryID > 0 {
+			category = &data.Category{ID: product.CategoryID, Name: product.Category}
+		}
+		categories[key] = category
+	} else if category == nil {
+		v.AddError("category", "must be an existing category")
+	} else {
+		product.CategoryID = category.ID
+		product.Category = category.Name
+	}
+
+	data.ValidateProduct(v, product)
+	data.ValidateProductCategory(v, product)
+	if !v.IsEmpty() {
+		return nil, v.Errors, nil
+	}
+	return product, nil, nil
+}
+
+// the first line holds the column names, e.g
+// name,description,price,category,image_url,tags
+// tags are comma separated inside a quoted cell
+func readImportCSV(body io.Reader) ([]importRecord, error) {
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		switch name {
+		case "name", "description", "price", "category", "category_id", "image_url", "tags":
+		default:
+			return nil, fmt.Errorf("the csv contains unknown column %q", name)
+		}
+		columns[name] = i
+	}
+
+	records := []importRecord{}
+	for {
+		row, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(records) == data.MaxImportRows {
+			return nil, fmt.Errorf("the body must not contain more than %d products", data.MaxImportRows)
+		}
+
+		line, _ := reader.FieldPos(0)
+		record := importRecord{line: line}
+		cell := func(name string) string {
+			i, ok := columns[name]
+			if !ok || i >= len(row) {
+				return ""
+			}
+			return strings.TrimSpace(row[i])
+		}
+		addError := func(key, message string) {
+			if record.errors == nil {
+				record.errors = map[string]string{}
+			}
+			record.errors[key] = message
+		}
+
+		if len(row) != len(header) {
+			addError("row", fmt.Sprintf("must have %d columns", len(header)))
+		}
+		record.product.Name = cell("name")
+		record.product.Description = cell("description")
+		record.product.Category = cell("category")
+		record.product.ImageUrl = cell("image_url")
+		if tags := cell("tags"); tags != "" {
+			record.product.Tags = strings.Split(tags, ",")
+		}
+		if price := cell("price"); price != "" {
+			value, err := strconv.ParseFloat(price, 32)
+			if err != nil {
+				addError("price", "must be a number")
+			}
+			record.product.Price = float32(value)
+		}
+		if categoryID := cell("category_id"); categoryID != "" {
+			value, err := strconv.ParseInt(categoryID, 10, 64)
+			if err != nil {
+				addError("category_id", "must be an integer value")
+			}
+			record.product.CategoryID = &value
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// one json product per line, blank lines are ignored
+func readImportNDJSON(body io.Reader) ([]importRecord, error) {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	records := []importRecord{}
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		if len(records) == data.MaxImportRows {
+			return nil, fmt.Errorf("the body must not contain more than %d products", data.MaxImportRows)
+		}
+
+		record := importRecord{line: line}
+		dec := json.NewDecoder(bytes.NewReader(text))
+		dec.DisallowUnknownFields()
+		err := dec.Decode(&record.product)
+		if err == nil && dec.More() {
+			err = errors.New("must only contain a single JSON value")
+		}
+		if err != nil {
+			record.errors = map[string]string{"row": err.Error()}
+		}
+		records = append(records, record)
+	}
+	err := scanner.Err()
+	if errors.Is(err, bufio.ErrTooLong) {
+		return nil, fmt.Errorf("line %d is longer than 1MB", line+1)
+	}
+	return records, err
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/abner-tech/Test1/internal/data"
+	"github.com/abner-tech/Test1/internal/validator"
+)
+
+func (a *applicationDependences) suggestProductsHandler(w http.ResponseWriter, r *http.Request) {
+	//e.g ?q=keyb&limit=8
+	queryParameter := r.URL.Query()
+	v := validator.New()
+	q := a.getSingleQueryParameter(queryParameter, "q", "")
+	limit := a.getSingleIntigerParameter(queryParameter, "limit", 8, v)
+
+	data.ValidateSuggest(v, q, limit)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	suggestions, err := a.productModel.SuggestProducts(q, limit)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data := envelope{
+		"suggestions": suggestions,
+	}
+	err = a.writeResponse(w, r, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/abner-tech/Test1/internal/data"
+	"github.com/abner-tech/Test1/internal/validator"
+)
+
+func (a *applicationDependences) listProductVersionsHandler(w http.ResponseWriter, r *http.Request) {
+	id := a.productIdExist(w, r)
+	if id <= 0 {
+		//error was already printed in productIdExist()
+		return
+	}
+
+	versions, err := a.productModel.GetProductVersions(id)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data := envelope{
+		"versions": versions,
+	}
+	err = a.writeResponse(w, r, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// write an old version of a product back as a new version
+func (a *applicationDependences) revertProductVersionHandler(w http.ResponseWriter, r *http.Request) {
+	product, err := a.fetchProductByID(w, r, false)
+	if err != nil {
+		//error was already printed in fetchProductByID()
+		return
+	}
+
+	versionNumber, err := a.readIDParam(r, "v")
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	snapshot, err := a.productModel.GetProductVersion(product.ID, versionNumber)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	//the client can say which version it expects to be replacing
+	expectedVersion, ok, err := a.readExpectedVersion(r, nil)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+	if ok && expectedVersion != int64(product.Version) {
+		a.editConflictResponse(w, r)
+		return
+	}
+
+	product.Name = snapshot.Name
+	product.Description = snapshot.Description
+	product.Price = snapshot.Price
+	product.ImageUrl = snapshot.ImageUrl
+
+	//older versions may not pass the rules we have today, and their
+	//category has to still exist
+	v := validator.New()
+	err = a.resolveProductCategory(v, product, nil, &snapshot.Category)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	data.ValidateProduct(v, product)
+	data.ValidateProductCategory(v, product)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = a.productModel.UpdateProducts(product)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			a.editConflictResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	data := envelope{
+		"product": product,
+	}
+	err = a.writeResponse(w, r, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// runs until ctx is cancelled at shutdown, every interval it permanently
+// removes products that were soft deleted longer than the retention period.
+// a purge that has started is allowed to finish
+func (a *applicationDependences) purgeDeletedProducts(ctx context.Context) {
+	if a.config.purge.retention <= 0 || a.config.purge.interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(a.config.purge.interval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := a.productModel.PurgeDeletedProducts(a.config.purge.retention)
+		if err != nil {
+			a.logger.Error(err.Error())
+		} else if purged > 0 {
+			a.logger.Info("purged soft deleted products", "count", purged)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
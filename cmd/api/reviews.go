@@ -12,11 +12,9 @@ import (
 func (a *applicationDependences) create_P_ReviewHandler(w http.ResponseWriter, r *http.Request) {
 	//struct to hold a review
 	var incommingData struct {
-		ProductID    int64  `json:"product_id"`
-		UserName     string `json:"user_name"`
-		Rating       int8   `json:"rating"`
-		ReviewText   string `json:"review_text"`
-		HelpfulCount int8   `json:"helpful_count"`
+		ProductID  int64  `json:"product_id"`
+		Rating     int8   `json:"rating"`
+		ReviewText string `json:"review_text"`
 	}
 
 	//decoding
@@ -26,12 +24,15 @@ func (a *applicationDependences) create_P_ReviewHandler(w http.ResponseWriter, r
 		return
 	}
 
+	//the author is the authenticated user, the name shown comes from their account.
+	//helpful_count is only changed by votes so it always starts at zero
+	user := a.contextGetUser(r)
 	review := &data.Review{
-		ProductID:    incommingData.ProductID,
-		UserName:     incommingData.UserName,
-		Rating:       incommingData.Rating,
-		ReviewText:   incommingData.ReviewText,
-		HelpfulCount: incommingData.HelpfulCount,
+		ProductID:  incommingData.ProductID,
+		UserID:     user.ID,
+		UserName:   user.Name,
+		Rating:     incommingData.Rating,
+		ReviewText: incommingData.ReviewText,
 	}
 
 	v := validator.New()
@@ -61,7 +62,7 @@ func (a *applicationDependences) create_P_ReviewHandler(w http.ResponseWriter, r
 	data := envelope{
 		"review": review,
 	}
-	err = a.writeJSON(w, http.StatusCreated, data, headers)
+	err = a.writeResponse(w, r, http.StatusCreated, data, headers)
 	if err != nil {
 		a.serverErrorResponse(w, r, err)
 		return
@@ -86,16 +87,22 @@ func (a *applicationDependences) fetchReviewByIDS(w http.ResponseWriter, r *http
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
 			a.notFoundResponse(w, r)
-			return nil, err
 		default:
 			a.serverErrorResponse(w, r, err)
 		}
-
+		return nil, err
 	}
 	return review, nil
 }
 
 func (a *applicationDependences) listSingleProductReviewHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	fields := a.readFields(r.URL.Query(), data.ReviewFieldSafeList, v)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
 	review, err := a.fetchReviewByIDS(w, r)
 	if err != nil {
 		//error was already printed before so we just come out of function
@@ -103,15 +110,30 @@ func (a *applicationDependences) listSingleProductReviewHandler(w http.ResponseW
 	}
 
 	data := envelope{
-		"review": review,
+		"review": withFields(review, fields),
 	}
 
-	err = a.writeJSON(w, http.StatusOK, data, nil)
+	err = a.writeResponse(w, r, http.StatusOK, data, nil)
 	if err != nil {
 		a.serverErrorResponse(w, r, err)
 	}
 }
 
+// the fields of a review a merge patch or json patch can change
+type reviewPatchDocument struct {
+	Rating     int8   `json:"rating"`
+	ReviewText string `json:"review_text"`
+	Version    int64  `json:"version"` //changing it says which version is being edited
+}
+
+func newReviewPatchDocument(review *data.Review) reviewPatchDocument {
+	return reviewPatchDocument{
+		Rating:     review.Rating,
+		ReviewText: review.ReviewText,
+		Version:    int64(review.Version),
+	}
+}
+
 func (a *applicationDependences) updateProductReviewByIDS_Handler(w http.ResponseWriter, r *http.Request) {
 	//getting review with 2 passed in parameters (review id and product id)
 	review, err := a.fetchReviewByIDS(w, r)
@@ -120,18 +142,56 @@ func (a *applicationDependences) updateProductReviewByIDS_Handler(w http.Respons
 		return
 	}
 
+	//only the author or a moderator can change a review
+	allowed, err := a.canModifyReview(r, review)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	if !allowed {
+		a.notPermittedResponse(w, r)
+		return
+	}
+
 	//just declare info which can be updated by person from the existing review
 	var incomingData struct {
 		Rating     *int8   `json:"rating"`
 		ReviewText *string `json:"review_text"`
+		Version    *int64  `json:"version"`
 	}
 
-	//decoding
-	err = a.readJSON(w, r, &incomingData)
+	//a merge patch or json patch is applied to the current review
+	var document reviewPatchDocument
+	jsonPatch, patched, err := a.readPatch(w, r, newReviewPatchDocument(review), &document)
+	if err != nil {
+		//error was already printed in readPatch()
+		return
+	}
+	if patched {
+		incomingData.Rating = &document.Rating
+		incomingData.ReviewText = &document.ReviewText
+		if document.Version != int64(review.Version) {
+			incomingData.Version = &document.Version
+		}
+	} else {
+		//decoding
+		err = a.readJSON(w, r, &incomingData)
+		if err != nil {
+			a.badRequestResponse(w, r, err)
+			return
+		}
+	}
+
+	//reject the edit straight away if the client is working on an old version
+	expectedVersion, ok, err := a.readExpectedVersion(r, incomingData.Version)
 	if err != nil {
 		a.badRequestResponse(w, r, err)
 		return
 	}
+	if ok && expectedVersion != int64(review.Version) {
+		a.editConflictResponse(w, r)
+		return
+	}
 
 	//verifying which fields have been changed
 	if incomingData.Rating != nil {
@@ -145,21 +205,26 @@ func (a *applicationDependences) updateProductReviewByIDS_Handler(w http.Respons
 	v := validator.New()
 	data.ValidateReview(v, review)
 	if !v.IsEmpty() {
-		a.failedValidationResponse(w, r, v.Errors)
+		a.failedValidationResponse(w, r, patchErrors(jsonPatch, v.Errors, nil))
 		return
 	}
 
 	//continue with update
 	err = a.reviewModel.UpdateReview(review)
 	if err != nil {
-		a.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			a.editConflictResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
 	data := envelope{
 		"review": review,
 	}
-	err = a.writeJSON(w, http.StatusOK, data, nil)
+	err = a.writeResponse(w, r, http.StatusOK, data, nil)
 	if err != nil {
 		a.serverErrorResponse(w, r, err)
 	}
@@ -167,17 +232,22 @@ func (a *applicationDependences) updateProductReviewByIDS_Handler(w http.Respons
 
 func (a *applicationDependences) deleteReviewByIDS_Handler(w http.ResponseWriter, r *http.Request) {
 
-	//first retrieve the id parameters for record to be deleted
-	//pid=product.id and rid=review.id
-	pid, err := a.readIDParam(r, "pid")
+	//first retrieve the review to be deleted so we know who wrote it
+	review, err := a.fetchReviewByIDS(w, r)
 	if err != nil {
-		a.notFoundResponse(w, r)
+		//error was already printed in fetchReviewByIDS()
 		return
 	}
-	rid, err := a.readIDParam(r, "rid")
-	//using one if loop to check error for both pid and rid retrieval
+	pid, rid := review.ProductID, review.ID
+
+	//only the author or a moderator can delete a review
+	allowed, err := a.canModifyReview(r, review)
 	if err != nil {
-		a.notFoundResponse(w, r)
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	if !allowed {
+		a.notPermittedResponse(w, r)
 		return
 	}
 
@@ -196,12 +266,16 @@ func (a *applicationDependences) deleteReviewByIDS_Handler(w http.ResponseWriter
 	data := envelope{
 		"message": fmt.Sprintf("review with review id: %d and product id: %d deletet sucessfully", rid, pid),
 	}
-	err = a.writeJSON(w, http.StatusOK, data, nil)
+	err = a.writeResponse(w, r, http.StatusOK, data, nil)
 	if err != nil {
 		a.serverErrorResponse(w, r, err)
 	}
 }
 
+// the columns reviews can be sorted by
+var reviewSortSafeList = []string{"id", "rating", "helpful_count", "created_at",
+	"-id", "-rating", "-helpful_count", "-created_at"}
+
 func (a *applicationDependences) listReviewHandler(w http.ResponseWriter, r *http.Request) {
 	productID, err := a.readIDParam(r, "pid")
 	if err != nil || productID < 1 {
@@ -224,8 +298,12 @@ func (a *applicationDependences) listReviewHandler(w http.ResponseWriter, r *htt
 
 	queryParameterData.Filters.Page = a.getSingleIntigerParameter(queryParameter, "page", 1, v)
 	queryParameterData.Filters.PageSize = a.getSingleIntigerParameter(queryParameter, "page_size", 10, v)
-	queryParameterData.Filters.Sorting = a.getSingleQueryParameter(queryParameter, "sorting", "id")
-	queryParameterData.Filters.SortSafeList = []string{"id", "id", "-id", "-id"}
+	queryParameterData.Filters.Sorting = a.getSingleQueryParameter(queryParameter, "sort", "id")
+	//sending cursor (empty for the first page) switches to cursor paging
+	queryParameterData.Filters.UseCursor = queryParameter.Has("cursor")
+	queryParameterData.Filters.Cursor = queryParameter.Get("cursor")
+	queryParameterData.Filters.SortSafeList = reviewSortSafeList
+	queryParameterData.Filters.Fields = a.readFields(queryParameter, data.ReviewFieldSafeList, v)
 
 	//validate pagination filters
 	data.ValidateFilters(v, queryParameterData.Filters)
@@ -247,10 +325,10 @@ func (a *applicationDependences) listReviewHandler(w http.ResponseWriter, r *htt
 	}
 
 	data := envelope{
-		"products":  reviews,
+		"products":  listWithFields(reviews, queryParameterData.Filters.Fields),
 		"@metadata": metadata,
 	}
-	err = a.writeJSON(w, http.StatusOK, data, nil)
+	err = a.writeResponse(w, r, http.StatusOK, data, nil)
 	if err != nil {
 		a.serverErrorResponse(w, r, err)
 		return
@@ -258,6 +336,15 @@ func (a *applicationDependences) listReviewHandler(w http.ResponseWriter, r *htt
 }
 
 func (a *applicationDependences) increaseHelpfulCount(w http.ResponseWriter, r *http.Request) {
+	a.helpfulVoteHandler(w, r, true)
+}
+
+func (a *applicationDependences) retractHelpfulVoteHandler(w http.ResponseWriter, r *http.Request) {
+	a.helpfulVoteHandler(w, r, false)
+}
+
+// add or remove the current user's helpful vote on a review
+func (a *applicationDependences) helpfulVoteHandler(w http.ResponseWriter, r *http.Request, add bool) {
 	//fetch review id from url
 	reviewID, err := a.readIDParam(r, "rid")
 	if err != nil {
@@ -265,25 +352,65 @@ func (a *applicationDependences) increaseHelpfulCount(w http.ResponseWriter, r *
 		return
 	}
 
-	review, err := a.reviewModel.GetAndIncrementHelpfulCount(reviewID)
+	user := a.contextGetUser(r)
+	var review *data.Review
+	if add {
+		review, err = a.reviewModel.AddHelpfulVote(reviewID, user.ID)
+	} else {
+		review, err = a.reviewModel.RemoveHelpfulVote(reviewID, user.ID)
+	}
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
 			a.notFoundResponse(w, r)
-			return
 		default:
 			a.serverErrorResponse(w, r, err)
-			return
 		}
-
+		return
 	}
 
 	data := envelope{
 		"review": review,
 	}
-	err = a.writeJSON(w, http.StatusOK, data, nil)
+	err = a.writeResponse(w, r, http.StatusOK, data, nil)
 	if err != nil {
 		a.serverErrorResponse(w, r, err)
 	}
+}
+
+// check if the current user wrote the review or is a moderator
+func (a *applicationDependences) canModifyReview(r *http.Request, review *data.Review) (bool, error) {
+	user := a.contextGetUser(r)
+	if review.UserID != 0 && review.UserID == user.ID {
+		return true, nil
+	}
 
+	permissions, err := a.permissionModel.GetAllForUser(user.ID)
+	if err != nil {
+		return false, err
+	}
+	return permissions.Include(data.PermissionReviewsModerate), nil
+}
+
+func (a *applicationDependences) ratingSummaryHandler(w http.ResponseWriter, r *http.Request) {
+	//make sure the product exists first, 404 if it does not
+	id := a.productIdExist(w, r)
+	if id <= 0 {
+		//error was already printed in productIdExist()
+		return
+	}
+
+	summary, err := a.reviewModel.GetRatingSummary(id)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data := envelope{
+		"rating_summary": summary,
+	}
+	err = a.writeResponse(w, r, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
 }
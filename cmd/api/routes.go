@@ -3,6 +3,7 @@ package main
 import (
 	"net/http"
 
+	"github.com/abner-tech/Test1/internal/data"
 	"github.com/julienschmidt/httprouter"
 )
 
@@ -20,32 +21,72 @@ func (a *applicationDependences) routes() http.Handler {
 
 	//setup routes for the products table d\atabase interaction
 	//display all products
-	router.HandlerFunc(http.MethodPost, "/v1/products", a.createProductHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/products", a.requirePermission(data.PermissionProductsWrite, a.createProductHandler))
 	//display a specific product
 	router.HandlerFunc(http.MethodGet, "/v1/product/:pid", a.displayProductHandler)
 	//update a specific product
-	router.HandlerFunc(http.MethodPatch, "/v1/product/:pid", a.updateProductHandler)
+	router.HandlerFunc(http.MethodPatch, "/v1/product/:pid", a.requirePermission(data.PermissionProductsWrite, a.updateProductHandler))
 	//delette a specific product
-	router.HandlerFunc(http.MethodDelete, "/v1/product/:pid", a.deleteProductHandler)
+	router.HandlerFunc(http.MethodDelete, "/v1/product/:pid", a.requirePermission(data.PermissionProductsWrite, a.deleteProductHandler))
 	//display all products--includes sorting, filetering and searching
 	router.HandlerFunc(http.MethodGet, "/v1/products", a.listProductHandler)
+	//autocomplete product and category names while typing
+	router.HandlerFunc(http.MethodGet, "/v1/products/suggest", a.suggestProductsHandler)
+	//the whole catalog as csv or ndjson, same filters as the list
+	router.HandlerFunc(http.MethodGet, "/v1/products/export", a.exportProductsHandler)
+	//bulk create products from a csv or ndjson file
+	router.HandlerFunc(http.MethodPost, "/v1/products/import", a.requirePermission(data.PermissionProductsWrite, a.importProductsHandler))
+	//bring back a soft deleted product
+	router.HandlerFunc(http.MethodPost, "/v1/product/:pid/restore", a.requirePermission(data.PermissionProductsWrite, a.restoreProductHandler))
+	//every saved version of a product with what changed between them
+	router.HandlerFunc(http.MethodGet, "/v1/product/:pid/versions", a.listProductVersionsHandler)
+	//write an old version back as a new version
+	router.HandlerFunc(http.MethodPost, "/v1/product/:pid/versions/:v/revert", a.requirePermission(data.PermissionProductsWrite, a.revertProductVersionHandler))
+	//when and how the price of a product changed
+	router.HandlerFunc(http.MethodGet, "/v1/product/:pid/price-history", a.listPriceHistoryHandler)
+	//breakdown of the 1-5 star ratings of a product
+	router.HandlerFunc(http.MethodGet, "/v1/product/:pid/rating-summary", a.ratingSummaryHandler)
+
+	//setup routes for the categories
+	router.HandlerFunc(http.MethodGet, "/v1/categories", a.listCategoriesHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/categories", a.requirePermission(data.PermissionProductsWrite, a.createCategoryHandler))
+	//display a category and its sub categories
+	router.HandlerFunc(http.MethodGet, "/v1/categories/:cid", a.displayCategoryHandler)
+	router.HandlerFunc(http.MethodPatch, "/v1/categories/:cid", a.requirePermission(data.PermissionProductsWrite, a.updateCategoryHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/categories/:cid", a.requirePermission(data.PermissionProductsWrite, a.deleteCategoryHandler))
+
+	//every tag with how many products use it
+	router.HandlerFunc(http.MethodGet, "/v1/tags", a.listTagsHandler)
 
 	//setup routes for the reviews table database interactions
 	//create a review for a porduct using product id
-	router.HandlerFunc(http.MethodPost, "/v1/reviews/:pid", a.create_P_ReviewHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/reviews/:pid", a.requirePermission(data.PermissionReviewsWrite, a.create_P_ReviewHandler))
 	//list a specific review for a product
 	router.HandlerFunc(http.MethodGet, "/v1/product/:pid/review/:rid", a.listSingleProductReviewHandler)
-	//update a specific review for a specific product
-	router.HandlerFunc(http.MethodPatch, "/v1/product/:pid/review/:rid", a.updateProductReviewByIDS_Handler)
-	//delete a review
-	router.HandlerFunc(http.MethodDelete, "/v1/product/:pid/review/:rid", a.deleteReviewByIDS_Handler)
+	//update a specific review for a specific product (author or moderator only)
+	router.HandlerFunc(http.MethodPatch, "/v1/product/:pid/review/:rid", a.requireActivatedUser(a.updateProductReviewByIDS_Handler))
+	//delete a review (author or moderator only)
+	router.HandlerFunc(http.MethodDelete, "/v1/product/:pid/review/:rid", a.requireActivatedUser(a.deleteReviewByIDS_Handler))
 	//display all reviews
 	router.HandlerFunc(http.MethodGet, "/v1/reviews", a.listReviewHandler)
+	//every review as csv or ndjson, same filters as the list
+	router.HandlerFunc(http.MethodGet, "/v1/reviews/export", a.exportReviewsHandler)
 	//display a// review for a specific product
 	router.HandlerFunc(http.MethodGet, "/v1/prod/reviews/:pid", a.listReviewHandler)
 
 	//setup route for the reviews table in regards to helpful count
-	router.HandlerFunc(http.MethodPatch, "/v1/HelpfulCount/:rid", a.increaseHelpfulCount)
+	//one vote per user, voting again changes nothing
+	router.HandlerFunc(http.MethodPatch, "/v1/HelpfulCount/:rid", a.requireActivatedUser(a.increaseHelpfulCount))
+	//take back a helpful vote
+	router.HandlerFunc(http.MethodDelete, "/v1/HelpfulCount/:rid", a.requireActivatedUser(a.retractHelpfulVoteHandler))
+
+	//setup routes for user accounts
+	//register a new (inactive) user
+	router.HandlerFunc(http.MethodPost, "/v1/users", a.registerUserHandler)
+	//activate a user with the emailed token
+	router.HandlerFunc(http.MethodPut, "/v1/users/activated", a.activateUserHandler)
+	//log in, trade email and password for an authentication token
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", a.createAuthenticationTokenHandler)
 
-	return a.recoverPanic(a.rateLimiting(router))
+	return a.recoverPanic(a.negotiateContent(a.rateLimiting(a.authenticate(router))))
 }
@@ -24,20 +24,39 @@ func (a *applicationDependences) serve() error {
 
 	shutdownError := make(chan error)
 
+	//cancelled at shutdown to stop the background loops
+	stopCtx, stop := context.WithCancel(context.Background())
+	defer stop()
+
 	go func() {
 		quit := make(chan os.Signal, 1)                      //detect and receive shutdown signal
 		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM) //got signal
 		s := <-quit                                          //dont pass here until signal is received
 
 		a.logger.Info("shutting down server", "signal", s.String())
+		stop()
 
 		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 		defer cancel()
 
 		//if everything ok, start tru shutdown process
-		shutdownError <- apiServer.Shutdown(ctx)
+		err := apiServer.Shutdown(ctx)
+		if err != nil {
+			shutdownError <- err
+			return
+		}
+
+		//wait for background tasks such as sending emails to finish
+		a.logger.Info("completing background tasks", "address", apiServer.Addr)
+		a.wg.Wait()
+		shutdownError <- nil
 	}()
 
+	//remove products that have been soft deleted for too long
+	a.background(func() {
+		a.purgeDeletedProducts(stopCtx)
+	})
+
 	a.logger.Info("Starting Server", "address", apiServer.Addr, "environment", a.config.environment, "rateLimiterConfig", a.config.limiter)
 
 	err := apiServer.ListenAndServe()
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/abner-tech/Test1/internal/data"
+	"github.com/abner-tech/Test1/internal/validator"
+)
+
+func (a *applicationDependences) createAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var incomingData struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+
+	err := a.readJSON(w, r, &incomingData)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidateEmail(v, incomingData.Email)
+	data.ValidatePasswordPlaintext(v, incomingData.Password)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	//an unknown email and a wrong password get the same response
+	user, err := a.userModel.GetByEmail(incomingData.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.invalidCredentialsResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	match, err := user.Password.Matches(incomingData.Password)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	if !match {
+		a.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	//the token is valid for 24 hours, only its hash is stored
+	token, err := a.tokenModel.New(user.ID, 24*time.Hour, data.ScopeAuthentication)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data := envelope{
+		"authentication_token": token,
+	}
+	err = a.writeResponse(w, r, http.StatusCreated, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
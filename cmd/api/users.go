@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/abner-tech/Test1/internal/data"
+	"github.com/abner-tech/Test1/internal/validator"
+)
+
+func (a *applicationDependences) registerUserHandler(w http.ResponseWriter, r *http.Request) {
+	//struct to hold the new user's details
+	var incomingData struct {
+		Name     string `json:"name"`
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+
+	err := a.readJSON(w, r, &incomingData)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	//accounts start inactive until the emailed token is redeemed
+	user := &data.User{
+		Name:      incomingData.Name,
+		Email:     incomingData.Email,
+		Activated: false,
+	}
+
+	//check the plaintext before hashing it, bcrypt refuses passwords
+	//longer than 72 bytes
+	v := validator.New()
+	data.ValidatePasswordPlaintext(v, incomingData.Password)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = user.Password.Set(incomingData.Password)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data.ValidateUser(v, user)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	//every new user can post reviews once their account is activated, the
+	//user, permission and activation token are saved together
+	token, err := a.userModel.Register(user, 3*24*time.Hour, data.PermissionReviewsWrite)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateEmail):
+			v.AddError("email", "a user with this email address already exists")
+			a.failedValidationResponse(w, r, v.Errors)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	//send the welcome email in the background so the client does not wait for it
+	a.background(func() {
+		emailData := map[string]any{
+			"activationToken": token.Plaintext,
+			"name":            user.Name,
+			"userID":          user.ID,
+		}
+		err := a.mailer.Send(user.Email, "user_welcome.tmpl", emailData)
+		if err != nil {
+			a.logger.Error(err.Error())
+		}
+	})
+
+	//202 since the account still has to be activated
+	data := envelope{
+		"user": user,
+	}
+	err = a.writeResponse(w, r, http.StatusAccepted, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+func (a *applicationDependences) activateUserHandler(w http.ResponseWriter, r *http.Request) {
+	var incomingData struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := a.readJSON(w, r, &incomingData)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidateTokenPlaintext(v, incomingData.TokenPlaintext)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	//find the user the token was issued to
+	user, err := a.userModel.GetForToken(data.ScopeActivation, incomingData.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired activation token")
+			a.failedValidationResponse(w, r, v.Errors)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user.Activated = true
+	err = a.userModel.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			a.editConflictResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	//the token has been used so it cannot be used again
+	err = a.tokenModel.DeleteAllForUser(data.ScopeActivation, user.ID)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data := envelope{
+		"user": user,
+	}
+	err = a.writeResponse(w, r, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
@@ -8,3 +8,5 @@ require (
 )
 
 require golang.org/x/time v0.7.0
+
+require golang.org/x/crypto v0.28.0
@@ -0,0 +1,268 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/abner-tech/Test1/internal/validator"
+)
+
+var (
+	ErrDuplicateSlug = errors.New("duplicate slug")
+	ErrCategoryInUse = errors.New("category in use")
+	ErrCategoryLoop  = errors.New("category loop")
+)
+
+// regular expressions used to check and build slugs
+var (
+	SlugRX             = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+	nonSlugCharacterRX = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+type Category struct {
+	ID        int64       `json:"id"`
+	Name      string      `json:"name"`
+	Slug      string      `json:"slug"`
+	ParentID  *int64      `json:"parent_id"` //nil for top level categories
+	CreatedAt time.Time   `json:"created_at"`
+	Version   int32       `json:"version"`
+	Children  []*Category `json:"children,omitempty"`
+}
+
+// turn a name into a slug e.g "Home & Garden" becomes "home-garden"
+func Slugify(name string) string {
+	slug := nonSlugCharacterRX.ReplaceAllString(strings.ToLower(strings.TrimSpace(name)), "-")
+	return strings.Trim(slug, "-")
+}
+
+func ValidateCategory(v *validator.Validator, category *Category) {
+	v.Check(category.Name != "", "name", "must be provided")
+	v.Check(len(category.Name) <= 50, "name", "must not be more than 50 bytes")
+
+	v.Check(category.Slug != "", "slug", "must be provided")
+	v.Check(len(category.Slug) <= 50, "slug", "must not be more than 50 bytes")
+	v.Check(SlugRX.MatchString(category.Slug), "slug", "must only contain lowercase letters, numbers and dashes")
+
+	if category.ParentID != nil {
+		v.Check(*category.ParentID > 0, "parent_id", "must be a valid category id")
+		v.Check(*category.ParentID != category.ID, "parent_id", "must not be the category itself")
+	}
+}
+
+type CategoryModel struct {
+	DB *sql.DB
+}
+
+func (c CategoryModel) Insert(category *Category) error {
+	query := `
+	INSERT INTO categories (name, slug, parent_id)
+	VALUES ($1, $2, $3)
+	RETURNING id, created_at, version
+	`
+	args := []any{category.Name, category.Slug, category.ParentID}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := c.DB.QueryRowContext(ctx, query, args...).Scan(&category.ID, &category.CreatedAt, &category.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "categories_slug_key"`:
+			return ErrDuplicateSlug
+		case err.Error() == `pq: insert or update on table "categories" violates foreign key constraint "categories_parent_id_fkey"`:
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// get a category by its id
+func (c CategoryModel) Get(id int64) (*Category, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	return c.getWhere(`id = $1`, id)
+}
+
+// find a category from what a client sent, a slug or a name in any case
+func (c CategoryModel) Resolve(value string) (*Category, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, ErrRecordNotFound
+	}
+	return c.getWhere(`slug = $1 OR lower(name) = lower($1)`, value)
+}
+
+func (c CategoryModel) getWhere(condition string, arg any) (*Category, error) {
+	query := `
+	SELECT id, name, slug, parent_id, created_at, version
+	FROM categories
+	WHERE ` + condition + `
+	ORDER BY id
+	LIMIT 1
+	`
+	var category Category
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := c.DB.QueryRowContext(ctx, query, arg).Scan(
+		&category.ID,
+		&category.Name,
+		&category.Slug,
+		&category.ParentID,
+		&category.CreatedAt,
+		&category.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &category, nil
+}
+
+// get every category, or only the direct children of parentID when it is not nil
+func (c CategoryModel) GetAll(parentID *int64) ([]*Category, error) {
+	query := `
+	SELECT id, name, slug, parent_id, created_at, version
+	FROM categories
+	WHERE ($1::bigint IS NULL OR parent_id = $1)
+	ORDER BY name ASC, id ASC
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := c.DB.QueryContext(ctx, query, parentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	categories := []*Category{}
+	for rows.Next() {
+		var category Category
+		err := rows.Scan(
+			&category.ID,
+			&category.Name,
+			&category.Slug,
+			&category.ParentID,
+			&category.CreatedAt,
+			&category.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+		categories = append(categories, &category)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return categories, nil
+}
+
+// update a category, renaming it also updates the copy of the name kept on
+// its products. A category cannot be moved under one of its own descendants
+func (c CategoryModel) Update(category *Category) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := c.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if category.ParentID != nil {
+		query := `
+		WITH RECURSIVE descendants AS (
+			SELECT id FROM categories WHERE id = $1
+			UNION ALL
+			SELECT categories.id FROM categories
+			INNER JOIN descendants ON categories.parent_id = descendants.id
+		)
+		SELECT EXISTS (SELECT 1 FROM descendants WHERE id = $2)
+		`
+		var loop bool
+		err = tx.QueryRowContext(ctx, query, category.ID, *category.ParentID).Scan(&loop)
+		if err != nil {
+			return err
+		}
+		if loop {
+			return ErrCategoryLoop
+		}
+	}
+
+	query := `
+	UPDATE categories
+	SET name = $1, slug = $2, parent_id = $3, version = version + 1
+	WHERE id = $4 AND version = $5
+	RETURNING version
+	`
+	args := []any{category.Name, category.Slug, category.ParentID, category.ID, category.Version}
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&category.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "categories_slug_key"`:
+			return ErrDuplicateSlug
+		case err.Error() == `pq: insert or update on table "categories" violates foreign key constraint "categories_parent_id_fkey"`:
+			return ErrRecordNotFound
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE products SET category = $1 WHERE category_id = $2`, category.Name, category.ID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// delete a category, categories with children or products cannot be deleted
+func (c CategoryModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+	DELETE FROM categories
+	WHERE id = $1
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := c.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		switch {
+		case strings.HasPrefix(err.Error(), "pq: update or delete on table \"categories\" violates foreign key constraint"):
+			return ErrCategoryInUse
+		default:
+			return err
+		}
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
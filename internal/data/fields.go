@@ -0,0 +1,30 @@
+package data
+
+import (
+	"slices"
+
+	"github.com/abner-tech/Test1/internal/validator"
+)
+
+// the json fields a client can ask for with ?fields=
+var ProductFieldSafeList = []string{"id", "name", "description", "price", "category", "category_id", "image_url", "tags",
+	"average-rating", "review_count", "lowest_price_30d", "relevance", "highlights", "created_at", "deleted_at", "version"}
+
+var ReviewFieldSafeList = []string{"id", "product_id", "user_id", "user_name", "rating", "review_text", "helpful_count",
+	"created_at", "version"}
+
+func ValidateFields(v *validator.Validator, fields []string, safeList []string) {
+	for _, field := range fields {
+		v.Check(validator.PermittedValue(field, safeList...), "fields", "unknown field "+field)
+	}
+}
+
+// the select expression for a field when the client asked for it (no
+// fields means all of them), otherwise a cheap stand-in of the same type
+// so the rows scan the same way
+func fieldColumn(fields []string, field string, expression string, standIn string) string {
+	if len(fields) == 0 || slices.Contains(fields, field) {
+		return expression
+	}
+	return standIn
+}
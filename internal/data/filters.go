@@ -1,6 +1,11 @@
 package data
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
 	"strings"
 
 	"github.com/abner-tech/Test1/internal/validator"
@@ -14,14 +19,36 @@ type Filters struct {
 	PageSize     int //how many records per page
 	Sorting      string
 	SortSafeList []string
+	UseCursor    bool     //page with cursors instead of page numbers
+	Cursor       string   //opaque cursor sent back by the client, empty for the first page
+	Fields       []string //fields the client wants back, empty for all of them
 }
 
+// a cursor remembers the sort value and id of the row at the edge of a
+// page, it is sent to clients as base64 encoded json
+type cursor struct {
+	Sort     string  `json:"s"`
+	Value    *string `json:"v"` //nil when the row has no value for the sort column
+	ID       int64   `json:"id"`
+	Backward bool    `json:"b,omitempty"`
+}
+
+// the sort value and id of a fetched row, used to build the next cursors
+type cursorKey struct {
+	Value *string
+	ID    int64
+}
+
+var errInvalidCursor = errors.New("invalid cursor")
+
 type Metadata struct {
-	CurrentPage  int `json:"current_page, omitempty"`
-	PageSize     int `json:"page_size, omitempty"`
-	FirstPage    int `json:"page_size, omitempty"`
-	LastPage     int `json:"last_page, omitempty"`
-	TotalRecords int `json:"total_records, omitempty"`
+	CurrentPage  int    `json:"current_page,omitempty"`
+	PageSize     int    `json:"page_size,omitempty"`
+	FirstPage    int    `json:"first_page,omitempty"`
+	LastPage     int    `json:"last_page,omitempty"`
+	TotalRecords int    `json:"total_records,omitempty"`
+	NextCursor   string `json:"next_cursor,omitempty"`
+	PrevCursor   string `json:"prev_cursor,omitempty"`
 }
 
 //we validate page and Page size
@@ -33,18 +60,49 @@ func ValidateFilters(v *validator.Validator, f Filters) {
 	v.Check(f.PageSize > 0, "page_size", "must be greator than zero")
 	v.Check(f.PageSize <= 100, "page_size", "must be a maximun of 100")
 
-	//check if provided sort values are valid
-	v.Check(validator.PermittedValue(f.Sorting, f.SortSafeList...), "sort", "invalid sort value")
+	//cursors are keyed on one sort column plus id, so only one key is allowed
+	if f.UseCursor {
+		v.Check(f.Page == 1, "page", "must not be used together with cursor")
+		v.Check(!strings.Contains(f.Sorting, ","), "sort", "only a single sort value can be used with cursor")
+		c, err := f.decodeCursor()
+		if err != nil {
+			v.AddError("cursor", "invalid cursor")
+		} else if c != nil {
+			v.Check(c.Sort == f.Sorting, "cursor", "was created for a different sort value")
+		}
+	}
+
+	ValidateSort(v, f)
+}
+
+// check if provided sort values are valid, several keys can be
+// given separated by commas e.g sort=-average_rating,price.
+// used on its own when there is no paging (exports)
+func ValidateSort(v *validator.Validator, f Filters) {
+	seen := make(map[string]bool)
+	for _, key := range f.sortKeys() {
+		v.Check(validator.PermittedValue(key, f.SortSafeList...), "sort", "invalid sort value")
+		column := strings.TrimPrefix(key, "-")
+		v.Check(!seen[column], "sort", "must not contain the same column more than once")
+		seen[column] = true
+	}
 }
 
 // calculate how many results to send back
+// with cursors we fetch one extra row to know if there is another page
 func (f Filters) limit() int {
+	if f.UseCursor {
+		return f.PageSize + 1
+	}
 	return f.PageSize
 }
 
 // calculate the offset so that we remember how many records have been sent
 // and how many remain to be sent
 func (f Filters) offset() int {
+	if f.UseCursor {
+		return 0
+	}
 	return (f.Page - 1) * f.PageSize
 }
 
@@ -62,23 +120,183 @@ func calculateMetaData(totalRecords int, currentPage int, pageSize int) Metadata
 	}
 }
 
-func (f Filters) sortColumn() string {
+// split the sort value into its individual keys
+func (f Filters) sortKeys() []string {
+	return strings.Split(f.Sorting, ",")
+}
+
+func (f Filters) sortColumn(key string) string {
 	//implementing sorting feature
 	for _, safeValue := range f.SortSafeList {
-		if f.Sorting == safeValue {
-			return strings.TrimPrefix(f.Sorting, "-")
+		if key == safeValue {
+			return strings.TrimPrefix(key, "-")
 		}
 	}
 
 	//not continue operation in case of injection attack
-	panic("unsafe sort parameter: " + f.Sorting)
+	panic("unsafe sort parameter: " + key)
 
 }
 
 // get the sort order
-func (f Filters) sortDirection() string {
-	if strings.HasPrefix(f.Sorting, "-") {
+func sortDirection(key string) string {
+	if strings.HasPrefix(key, "-") {
+		return "DESC"
+	}
+	return "ASC"
+}
+
+// build the ORDER BY list from every sort key, id is added at the
+// end (if not asked for) so that the order is the same every time
+func (f Filters) orderBy() string {
+	//with cursors id follows the direction of the sort column so that
+	//(column, id) can be compared as a single row value
+	if f.UseCursor {
+		key := f.sortKeys()[0]
+		column := f.sortColumn(key)
+		direction := sortDirection(key)
+		c, _ := f.decodeCursor()
+		if c != nil && c.Backward {
+			direction = reverseDirection(direction)
+		}
+		if column == "id" {
+			return "id " + direction
+		}
+		//rows without a value are treated as the largest ones, spelled out
+		//so the order matches cursorCondition()
+		return fmt.Sprintf("%s %s %s, id %s", column, direction, nullsOrder(direction), direction)
+	}
+
+	clauses := []string{}
+	for _, key := range f.sortKeys() {
+		column := f.sortColumn(key)
+		clauses = append(clauses, fmt.Sprintf("%s %s", column, sortDirection(key)))
+		//id is unique so any keys after it would never be used
+		if column == "id" {
+			return strings.Join(clauses, ", ")
+		}
+	}
+	clauses = append(clauses, "id ASC")
+	return strings.Join(clauses, ", ")
+}
+
+// where rows with a NULL sort value go, after every value going up
+// and before them going down
+func nullsOrder(direction string) string {
+	if direction == "ASC" {
+		return "NULLS LAST"
+	}
+	return "NULLS FIRST"
+}
+
+func reverseDirection(direction string) string {
+	if direction == "ASC" {
 		return "DESC"
 	}
 	return "ASC"
 }
+
+// the column the cursor is keyed on, selected as text (or NULL) with every row
+func (f Filters) cursorColumn() string {
+	return f.sortColumn(f.sortKeys()[0]) + "::text"
+}
+
+// decode the cursor sent by the client, nil means start from the beginning
+func (f Filters) decodeCursor() (*cursor, error) {
+	if f.Cursor == "" {
+		return nil, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(f.Cursor)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	var c cursor
+	err = json.Unmarshal(raw, &c)
+	if err != nil || c.ID < 1 {
+		return nil, errInvalidCursor
+	}
+	return &c, nil
+}
+
+func encodeCursor(c cursor) string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// the extra WHERE condition used to continue after the cursor, placeholders
+// are numbered from argIndex. Returns an empty condition when there is no cursor
+func (f Filters) cursorCondition(argIndex int) (string, []any, error) {
+	if !f.UseCursor {
+		return "", nil, nil
+	}
+	c, err := f.decodeCursor()
+	if err != nil {
+		return "", nil, err
+	}
+	if c == nil {
+		return "", nil, nil
+	}
+
+	key := f.sortKeys()[0]
+	column := f.sortColumn(key)
+	//forward through an ASC sort means larger values
+	comparison := ">"
+	if (sortDirection(key) == "DESC") != c.Backward {
+		comparison = "<"
+	}
+
+	if column == "id" {
+		return fmt.Sprintf("AND id %s $%d", comparison, argIndex), []any{c.ID}, nil
+	}
+
+	//NULL sort values come after every other value (see orderBy()), a row
+	//comparison would skip them so they get their own branch
+	switch {
+	case c.Value == nil && comparison == ">":
+		//only the rest of the NULL rows are left
+		return fmt.Sprintf("AND (%s IS NULL AND id > $%d)", column, argIndex), []any{c.ID}, nil
+	case c.Value == nil:
+		//going down from the NULL rows every row with a value is still to come
+		return fmt.Sprintf("AND ((%s IS NULL AND id < $%d) OR %s IS NOT NULL)", column, argIndex, column), []any{c.ID}, nil
+	case comparison == ">":
+		//the NULL rows are still to come
+		condition := fmt.Sprintf("AND ((%s, id) > ($%d, $%d) OR %s IS NULL)", column, argIndex, argIndex+1, column)
+		return condition, []any{*c.Value, c.ID}, nil
+	default:
+		condition := fmt.Sprintf("AND (%s, id) < ($%d, $%d)", column, argIndex, argIndex+1)
+		return condition, []any{*c.Value, c.ID}, nil
+	}
+}
+
+// trim the extra row we fetched, put a backward page back in order and
+// work out the next and previous cursors
+func cursorPage[T any](f Filters, records []T, keys []cursorKey) ([]T, Metadata) {
+	c, _ := f.decodeCursor()
+	backward := c != nil && c.Backward
+
+	hasMore := len(records) > f.PageSize
+	if hasMore {
+		records = records[:f.PageSize]
+		keys = keys[:f.PageSize]
+	}
+	if backward {
+		slices.Reverse(records)
+		slices.Reverse(keys)
+	}
+
+	metadata := Metadata{PageSize: f.PageSize}
+	if len(records) == 0 {
+		return records, metadata
+	}
+
+	first, last := keys[0], keys[len(keys)-1]
+	//going forward there is a next page if we got the extra row, and a previous
+	//one if we started from a cursor. Going backward it is the other way around
+	if hasMore || backward {
+		metadata.NextCursor = encodeCursor(cursor{Sort: f.Sorting, Value: last.Value, ID: last.ID})
+	}
+	if (backward && hasMore) || (!backward && c != nil) {
+		metadata.PrevCursor = encodeCursor(cursor{Sort: f.Sorting, Value: first.Value, ID: first.ID, Backward: true})
+	}
+	return records, metadata
+}
@@ -0,0 +1,52 @@
+package data
+
+import (
+	"context"
+	"time"
+)
+
+// one change to the price of a product
+type PriceChange struct {
+	ProductID int64     `json:"product_id"`
+	OldPrice  float32   `json:"old_price"`
+	NewPrice  float32   `json:"new_price"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+// get the price changes of a product from (inclusive) up to to (exclusive),
+// both optional, oldest first
+func (p ProductModel) GetPriceHistory(pid int64, from *time.Time, to *time.Time) ([]*PriceChange, error) {
+	query := `
+	SELECT product_id, COALESCE(old_price, 0), COALESCE(new_price, 0), changed_at
+	FROM product_price_history
+	WHERE product_id = $1
+	AND ($2::timestamptz IS NULL OR changed_at >= $2)
+	AND ($3::timestamptz IS NULL OR changed_at < $3)
+	ORDER BY changed_at ASC, id ASC
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := p.DB.QueryContext(ctx, query, pid, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := []*PriceChange{}
+	for rows.Next() {
+		var change PriceChange
+		err := rows.Scan(&change.ProductID, &change.OldPrice, &change.NewPrice, &change.ChangedAt)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, &change)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return changes, nil
+}
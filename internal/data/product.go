@@ -5,24 +5,60 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/abner-tech/Test1/internal/validator"
+	"github.com/lib/pq"
 )
 
 // each name begins with uppercase to make them exportable/ public
 type Product struct {
-	ID            int64     `json:"id"`
-	Name          string    `json:"name"`
-	Description   string    `json:"description"`
-	Price         float32   `json:"price"`
-	Category      string    `json:"category"`
-	ImageUrl      string    `json:"image_url"`
-	AverageRating float32   `json:"average-rating"`
-	CreatedAt     time.Time `json:"created_at"`
-	Version       int32     `json:"version"`
+	ID             int64             `json:"id"`
+	Name           string            `json:"name"`
+	Description    string            `json:"description"`
+	Price          float32           `json:"price"`
+	Category       string            `json:"category"`
+	CategoryID     int64             `json:"category_id"`
+	ImageUrl       string            `json:"image_url"`
+	Tags           []string          `json:"tags"`
+	AverageRating  float32           `json:"average-rating"`
+	ReviewCount    int32             `json:"review_count"`
+	LowestPrice30d float32           `json:"lowest_price_30d"`    //lowest price in effect during the last 30 days
+	Relevance      float32           `json:"relevance,omitempty"` //only set when searching with q
+	Highlights     *SearchHighlights `json:"highlights,omitempty"`
+	CreatedAt      time.Time         `json:"created_at"`
+	DeletedAt      *time.Time        `json:"deleted_at,omitempty"` //only set for soft deleted products
+	Version        int32             `json:"version"`
 }
 
+// ProductQuery holds everything a client can filter the product list on.
+// the range fields are pointers so that we can tell when they were not sent
+type ProductQuery struct {
+	Category       string //slug or name, includes every sub category
+	Name           string
+	Description    string
+	MinPrice       *float64
+	MaxPrice       *float64
+	MinRating      *float64
+	CreatedAfter   *time.Time
+	CreatedBefore  *time.Time
+	IncludeDeleted bool     //admins can see soft deleted products
+	Tags           []string //products with these tags
+	TagMatch       string   //any or all of the tags
+	Search         string   //full text search over name, category and description
+	SearchConfig   string   //text search configuration, english or simple
+}
+
+// the lowest of the current price and every price replaced in the last
+// 30 days, LEAST ignores the NULL we get when the price never changed
+const productLowestPrice30d = `LEAST(price, (
+		SELECT MIN(old_price)
+		FROM product_price_history
+		WHERE product_price_history.product_id = products.id
+		AND product_price_history.changed_at >= NOW() - INTERVAL '30 days'
+	))`
+
 // commentModel that expects a connection pool
 type ProductModel struct {
 	DB *sql.DB
@@ -31,28 +67,58 @@ type ProductModel struct {
 // Insert Row to comments table
 // expects a pointer to the actual product content
 func (c ProductModel) InsertProduct(product *Product) error {
+	// Create a context with a 3-second timeout. No database
+	// operation should take more than 3 seconds or we will quit it
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// the product and its tags are saved together
+	tx, err := c.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = insertProduct(ctx, tx, product)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// insert the product and its tags as part of tx
+func insertProduct(ctx context.Context, tx *sql.Tx, product *Product) error {
 	//the sql query to be executed against the database table
 	query := `
-	INSERT INTO products (name, description, price, category, image_url)
-	VALUES ($1, $2, $3, $4, $5)
+	INSERT INTO products (name, description, price, category, category_id, image_url)
+	VALUES ($1, $2, $3, $4, $5, $6)
 	RETURNING id, created_at, version`
 
 	//the actual values to be passed into $1 and $2
-	args := []any{product.Name, product.Description, product.Price, product.Category, product.ImageUrl}
+	args := []any{product.Name, product.Description, product.Price, product.Category, product.CategoryID, product.ImageUrl}
+
+	// a new product has only ever had one price
+	product.LowestPrice30d = product.Price
+	if product.Tags == nil {
+		product.Tags = []string{}
+	}
 
-	// Create a context with a 3-second timeout. No database
-	// operation should take more than 3 seconds or we will quit it
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
 	// execute the query against the comments database table. We ask for the the
 	// id, created_at, and version to be sent back to us which we will use
 	// to update the Comment struct later on
-	return c.DB.QueryRowContext(ctx, query, args...).Scan(
+	err := tx.QueryRowContext(ctx, query, args...).Scan(
 		&product.ID,
 		&product.CreatedAt,
 		&product.Version)
+	if err != nil {
+		return err
+	}
+
+	return setProductTags(ctx, tx, product.ID, product.Tags)
 }
 
+// products created before categories existed may have no category row,
+// so the category is checked on its own (see ValidateProductCategory)
 func ValidateProduct(v *validator.Validator, product *Product) {
 	// Validate Name
 	v.Check(product.Name != "", "name", "must be provided")
@@ -65,27 +131,59 @@ func ValidateProduct(v *validator.Validator, product *Product) {
 	// Validate Price (ensure it is a positive number)
 	v.Check(product.Price > 0, "price", "must be a positive value")
 
-	// Validate Category (ensure it is not empty)
-	v.Check(product.Category != "", "category", "must be provided")
-	v.Check(len(product.Category) <= 50, "category", "must not be more than 50 bytes")
-
 	// Validate ImageUrl (ensure it is a valid URL format and not empty)
 	v.Check(product.ImageUrl != "", "image_url", "must be provided")
 	v.Check(len(product.ImageUrl) <= 200, "image_url", "must not be more than 200 bytes")
+
+	ValidateTags(v, "tags", product.Tags)
+}
+
+// the category must not be empty and must point at a category row, checked
+// when a product is created or its category is changed
+func ValidateProductCategory(v *validator.Validator, product *Product) {
+	v.Check(product.Category != "", "category", "must be provided")
+	v.Check(len(product.Category) <= 50, "category", "must not be more than 50 bytes")
+	v.Check(product.CategoryID > 0, "category", "must be an existing category")
+}
+
+func ValidateProductQuery(v *validator.Validator, query ProductQuery) {
+	if query.MinPrice != nil {
+		v.Check(*query.MinPrice >= 0, "min_price", "must not be negative")
+	}
+	if query.MaxPrice != nil {
+		v.Check(*query.MaxPrice >= 0, "max_price", "must not be negative")
+	}
+	if query.MinPrice != nil && query.MaxPrice != nil {
+		v.Check(*query.MinPrice <= *query.MaxPrice, "min_price", "must not be greater than max_price")
+	}
+
+	if query.MinRating != nil {
+		v.Check(*query.MinRating >= 0 && *query.MinRating <= 5, "min_rating", "must be a number between 0 and 5")
+	}
+
+	if query.CreatedAfter != nil && query.CreatedBefore != nil {
+		v.Check(!query.CreatedAfter.After(*query.CreatedBefore), "created_after", "must not be later than created_before")
+	}
+
+	ValidateTags(v, "tags", query.Tags)
+	ValidateSearch(v, query)
+	v.Check(validator.PermittedValue(query.TagMatch, TagMatchAny, TagMatchAll), "tag_match", "must be any or all")
 }
 
 // get a comment from DB based on ID
-func (p ProductModel) GetProduct(id int64) (*Product, error) {
+// soft deleted products are only returned when includeDeleted is true
+func (p ProductModel) GetProduct(id int64, includeDeleted bool) (*Product, error) {
 	//check if the id is valid
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
 	//the sql query to be excecuted against the database table
-	query := `
-	SELECT id, name, description, price, category, image_url, average_rating, created_at, version
+	query := fmt.Sprintf(`
+	SELECT id, name, description, price, category, COALESCE(category_id, 0), image_url, %s, average_rating, review_count, %s, created_at, deleted_at, version
 	FROM products
 	WHERE id = $1
-	`
+	AND (deleted_at IS NULL OR $2)
+	`, productTags, productLowestPrice30d)
 
 	//declare a variable of type Product to hold the returned values
 	var product Product
@@ -94,15 +192,20 @@ func (p ProductModel) GetProduct(id int64) (*Product, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := p.DB.QueryRowContext(ctx, query, id).Scan(
+	err := p.DB.QueryRowContext(ctx, query, id, includeDeleted).Scan(
 		&product.ID,
 		&product.Name,
 		&product.Description,
 		&product.Price,
 		&product.Category,
+		&product.CategoryID,
 		&product.ImageUrl,
+		pq.Array(&product.Tags),
 		&product.AverageRating,
+		&product.ReviewCount,
+		&product.LowestPrice30d,
 		&product.CreatedAt,
+		&product.DeletedAt,
 		&product.Version,
 	)
 	//check for errors
@@ -117,23 +220,127 @@ func (p ProductModel) GetProduct(id int64) (*Product, error) {
 	return &product, nil
 }
 
-func (p ProductModel) GetAllProducts(category string, name string, description string, filters Filters) ([]*Product, Metadata, error) {
+// build the WHERE conditions for a product query. skip leaves out the filters
+// of one facet dimension (used for facet counts), "" applies every filter.
+// placeholders are numbered from $1 in the order of the returned args
+func (q ProductQuery) where(skip string) (string, []any) {
+	args := []any{}
+	arg := func(value any) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	conditions := []string{}
+	if !q.IncludeDeleted {
+		conditions = append(conditions, "deleted_at IS NULL")
+	}
+
+	//uses the stored, indexed search vectors
+	if q.Search != "" {
+		conditions = append(conditions, searchCondition(q.SearchConfig, arg(q.Search)))
+	}
+
+	//the category and every category below it
+	if q.Category != "" && skip != FacetCategory {
+		conditions = append(conditions, fmt.Sprintf(`category_id IN (
+		WITH RECURSIVE tree AS (
+			SELECT id FROM categories WHERE slug = %[1]s OR lower(name) = lower(%[1]s)
+			UNION ALL
+			SELECT categories.id FROM categories INNER JOIN tree ON categories.parent_id = tree.id
+		)
+		SELECT id FROM tree
+	)`, arg(q.Category)))
+	}
+
+	if q.Name != "" {
+		conditions = append(conditions, fmt.Sprintf("to_tsvector('simple', name) @@ plainto_tsquery('simple', %s)", arg(q.Name)))
+	}
+	if q.Description != "" {
+		conditions = append(conditions, fmt.Sprintf("to_tsvector('simple', description) @@ plainto_tsquery('simple', %s)", arg(q.Description)))
+	}
+
+	if skip != FacetPrice {
+		if q.MinPrice != nil {
+			conditions = append(conditions, fmt.Sprintf("price >= %s", arg(*q.MinPrice)))
+		}
+		if q.MaxPrice != nil {
+			conditions = append(conditions, fmt.Sprintf("price <= %s", arg(*q.MaxPrice)))
+		}
+	}
+	if q.MinRating != nil && skip != FacetRating {
+		conditions = append(conditions, fmt.Sprintf("average_rating >= %s", arg(*q.MinRating)))
+	}
+
+	if q.CreatedAfter != nil {
+		conditions = append(conditions, fmt.Sprintf("created_at >= %s", arg(*q.CreatedAfter)))
+	}
+	//the end of the range is exclusive, a plain date is sent as the start of the next day
+	if q.CreatedBefore != nil {
+		conditions = append(conditions, fmt.Sprintf("created_at < %s", arg(*q.CreatedBefore)))
+	}
+
+	//with "all" a product needs every tag, with "any" one is enough
+	if len(q.Tags) > 0 {
+		needed := 1
+		if q.TagMatch == TagMatchAll {
+			needed = len(q.Tags)
+		}
+		conditions = append(conditions, fmt.Sprintf(`(
+		SELECT COUNT(*)
+		FROM product_tags
+		INNER JOIN tags ON tags.id = product_tags.tag_id
+		WHERE product_tags.product_id = products.id AND tags.name = ANY(%s)
+	) >= %s`, arg(pq.Array(q.Tags)), arg(needed)))
+	}
+
+	if len(conditions) == 0 {
+		return "TRUE", args
+	}
+	return strings.Join(conditions, "\n\tAND "), args
+}
+
+func (p ProductModel) GetAllProducts(productQuery ProductQuery, filters Filters) ([]*Product, Metadata, error) {
+	//only the filters the client sent end up in the query
+	where, args := productQuery.where("")
+
+	//rank and highlight the products when searching
+	searchPlaceholder := ""
+	if productQuery.Search != "" {
+		args = append(args, productQuery.Search)
+		searchPlaceholder = fmt.Sprintf("$%d", len(args))
+	}
+
+	//rows after the cursor (if any)
+	cursorCondition, cursorArgs, err := filters.cursorCondition(len(args) + 1)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	args = append(args, cursorArgs...)
+
 	query := fmt.Sprintf(`
-	SELECT COUNT(*) OVER(), id, name, description, price, category, image_url, average_rating, created_at, version
+	SELECT COUNT(*) OVER(), %s, id, %s, %s, price, %s, COALESCE(category_id, 0), %s, %s, average_rating, review_count, %s, created_at, deleted_at, version,
+		%s
 	FROM products
-	WHERE (to_tsvector('simple',category) @@
-		plainto_tsquery('simple', $1) OR $1 = '')
-	AND (to_tsvector('simple',name) @@
-		plainto_tsquery('simple',$2) OR $2 = '')
-	AND (to_tsvector('simple', description)@@
-		plainto_tsquery('simple', $3) OR $3 = '')
-	ORDER BY %s %s, id ASC
-	LIMIT $4 OFFSET $5
-	`, filters.sortColumn(), filters.sortDirection())
+	WHERE %s
+	%s
+	ORDER BY %s
+	LIMIT $%d OFFSET $%d
+	`, filters.cursorColumn(),
+		//only the text and computed columns the client asked for are read
+		fieldColumn(filters.Fields, "name", "name", "''"),
+		fieldColumn(filters.Fields, "description", "description", "''"),
+		fieldColumn(filters.Fields, "category", "category", "''"),
+		fieldColumn(filters.Fields, "image_url", "image_url", "''"),
+		fieldColumn(filters.Fields, "tags", productTags, "'{}'::text[]"),
+		fieldColumn(filters.Fields, "lowest_price_30d", productLowestPrice30d, "0"),
+		fieldColumn(filters.Fields, "highlights", searchColumns(productQuery.SearchConfig, searchPlaceholder), searchRank(productQuery.SearchConfig, searchPlaceholder)+", '', ''"),
+		where, cursorCondition, filters.orderBy(), len(args)+1, len(args)+2)
+	args = append(args, filters.limit(), filters.offset())
+
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	rows, err := p.DB.QueryContext(ctx, query, category, name, description, filters.limit(), filters.offset())
+	rows, err := p.DB.QueryContext(ctx, query, args...)
 	//check for errors
 	if err != nil {
 		switch {
@@ -147,20 +354,35 @@ func (p ProductModel) GetAllProducts(category string, name string, description s
 	defer rows.Close()
 	totalRecords := 0
 	products := []*Product{}
+	keys := []cursorKey{}
 
 	for rows.Next() {
 		var prod Product
-		err := rows.Scan(&totalRecords, &prod.ID, &prod.Name, &prod.Description, &prod.Price, &prod.Category, &prod.ImageUrl, &prod.AverageRating, &prod.CreatedAt, &prod.Version)
+		var key cursorKey
+		var highlights SearchHighlights
+		err := rows.Scan(&totalRecords, &key.Value, &prod.ID, &prod.Name, &prod.Description, &prod.Price, &prod.Category, &prod.CategoryID, &prod.ImageUrl, pq.Array(&prod.Tags), &prod.AverageRating, &prod.ReviewCount, &prod.LowestPrice30d, &prod.CreatedAt, &prod.DeletedAt, &prod.Version,
+			&prod.Relevance, &highlights.Name, &highlights.Description)
 		if err != nil {
 			return nil, Metadata{}, err
 		}
+		if productQuery.Search != "" {
+			highlights.toHTML()
+			prod.Highlights = &highlights
+		}
+		key.ID = prod.ID
 		products = append(products, &prod)
+		keys = append(keys, key)
 	}
 	err = rows.Err()
 	if err != nil {
 		return nil, Metadata{}, err
 	}
 
+	if filters.UseCursor {
+		products, metadata := cursorPage(filters, products, keys)
+		return products, metadata, nil
+	}
+
 	//create the metadata
 	metadata := calculateMetaData(totalRecords, filters.Page, filters.PageSize)
 	return products, metadata, nil
@@ -171,20 +393,59 @@ func (p ProductModel) UpdateProducts(product *Product) error {
 	//the sql query to be excecuted against the DB table
 	//Every time make an update, version number is incremented
 
+	//the version we read earlier must still be the current one, otherwise
+	//somebody else has changed the record in the meantime. Older products
+	//without a category row keep a NULL category_id
 	query := `
 	UPDATE products
-	SET name=$1, description=$2, price=$3, category=$4, image_url=$5, version=version+1
-	WHERE id = $6
+	SET name=$1, description=$2, price=$3, category=$4, category_id=NULLIF($5::bigint, 0), image_url=$6, version=version+1
+	WHERE id = $7 AND version = $8 AND deleted_at IS NULL
 	RETURNING version
 	`
 
-	args := []any{product.Name, product.Description, product.Price, product.Category, product.ImageUrl, product.ID}
+	args := []any{product.Name, product.Description, product.Price, product.Category, product.CategoryID, product.ImageUrl, product.ID, product.Version}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
-	return p.DB.QueryRowContext(ctx, query, args...).Scan(&product.Version)
+
+	//the product and its tags are updated together
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&product.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	if product.Tags == nil {
+		product.Tags = []string{}
+	}
+	err = setProductTags(ctx, tx, product.ID, product.Tags)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	//the old price is already part of the lowest price we read, so only a
+	//lower new price can change it
+	if product.LowestPrice30d == 0 || product.Price < product.LowestPrice30d {
+		product.LowestPrice30d = product.Price
+	}
+	return nil
 }
 
-// delete a specific comment form the comments table
+// soft delete a specific product, it is hidden but can still be restored
+// and its reviews are kept until it is purged
 func (p ProductModel) DeleteProducts(id int64) error {
 	//check if the id is valid
 	if id < 1 {
@@ -193,8 +454,9 @@ func (p ProductModel) DeleteProducts(id int64) error {
 
 	//sql querry to be excecuted against the database table
 	query := `
-	DELETE FROM products
-	WHERE id = $1
+	UPDATE products
+	SET deleted_at = NOW()
+	WHERE id = $1 AND deleted_at IS NULL
 	`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -220,6 +482,56 @@ func (p ProductModel) DeleteProducts(id int64) error {
 	return nil
 }
 
+// bring back a soft deleted product, counts as a change so the version goes up
+func (p ProductModel) RestoreProduct(id int64) (*Product, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+	UPDATE products
+	SET deleted_at = NULL, version = version + 1
+	WHERE id = $1 AND deleted_at IS NOT NULL
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := p.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	//either the product does not exist or it was not deleted
+	if rowsAffected == 0 {
+		return nil, ErrRecordNotFound
+	}
+	return p.GetProduct(id, false)
+}
+
+// permanently remove products that were soft deleted longer than retention ago,
+// their reviews go with them. Returns how many products were removed
+func (p ProductModel) PurgeDeletedProducts(retention time.Duration) (int64, error) {
+	query := `
+	DELETE FROM products
+	WHERE deleted_at < $1
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	result, err := p.DB.ExecContext(ctx, query, time.Now().Add(-retention))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 func (p ProductModel) ProductExist(id int64) (int64, error) {
 	if id < 1 {
 		return 0, ErrRecordNotFound
@@ -227,7 +539,7 @@ func (p ProductModel) ProductExist(id int64) (int64, error) {
 	query := `
 	SELECT id 
 	FROM products
-	WHERE id = $1
+	WHERE id = $1 AND deleted_at IS NULL
 	LIMIT 1
 	`
 
@@ -248,3 +560,64 @@ func (p ProductModel) ProductExist(id int64) (int64, error) {
 
 	return *&product.ID, nil
 }
+
+// a product whose stored rating aggregates do not match its reviews
+type RatingDrift struct {
+	ProductID     int64   `json:"product_id"`
+	StoredAverage float64 `json:"stored_average"`
+	StoredCount   int     `json:"stored_count"`
+	ActualAverage float64 `json:"actual_average"`
+	ActualCount   int     `json:"actual_count"`
+}
+
+// compare average_rating and review_count of every product against the
+// reviews table. When repair is true the drifted products are recalculated
+func (p ProductModel) CheckRatingAggregates(repair bool) ([]RatingDrift, error) {
+	query := `
+	SELECT products.id, COALESCE(products.average_rating, 0), products.review_count,
+		COALESCE(ROUND(AVG(reviews.rating), 2), 0), COUNT(reviews.id)
+	FROM products
+	LEFT JOIN reviews ON reviews.product_id = products.id
+	GROUP BY products.id
+	HAVING products.average_rating IS DISTINCT FROM COALESCE(ROUND(AVG(reviews.rating), 2), 0)
+	OR products.review_count <> COUNT(reviews.id)
+	ORDER BY products.id
+	`
+
+	//this goes through the whole catalog so it gets more time than usual
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	rows, err := p.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	drifts := []RatingDrift{}
+	for rows.Next() {
+		var drift RatingDrift
+		err := rows.Scan(&drift.ProductID, &drift.StoredAverage, &drift.StoredCount, &drift.ActualAverage, &drift.ActualCount)
+		if err != nil {
+			return nil, err
+		}
+		drifts = append(drifts, drift)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	if !repair {
+		return drifts, nil
+	}
+
+	//use the same function as the trigger so both always agree
+	for _, drift := range drifts {
+		_, err := p.DB.ExecContext(ctx, `SELECT recalculate_product_rating($1)`, drift.ProductID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return drifts, nil
+}
@@ -0,0 +1,51 @@
+package data
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// call fn with every product matching the query, in the order of
+// filters.Sorting. Rows are read from the database one at a time so the
+// number of products does not change how much memory is used. Paging in
+// filters is ignored, ctx decides how long the export may take
+func (p ProductModel) ExportProducts(ctx context.Context, productQuery ProductQuery, filters Filters, fn func(*Product) error) error {
+	where, args := productQuery.where("")
+
+	//the relevance is needed when sorting by it
+	searchPlaceholder := ""
+	if productQuery.Search != "" {
+		args = append(args, productQuery.Search)
+		searchPlaceholder = fmt.Sprintf("$%d", len(args))
+	}
+
+	query := fmt.Sprintf(`
+	SELECT id, name, description, price, category, COALESCE(category_id, 0), image_url, %s, average_rating, review_count, %s, created_at, deleted_at, version,
+		%s
+	FROM products
+	WHERE %s
+	ORDER BY %s
+	`, productTags, productLowestPrice30d, searchRank(productQuery.SearchConfig, searchPlaceholder), where, filters.orderBy())
+
+	rows, err := p.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var prod Product
+		err := rows.Scan(&prod.ID, &prod.Name, &prod.Description, &prod.Price, &prod.Category, &prod.CategoryID, &prod.ImageUrl, pq.Array(&prod.Tags), &prod.AverageRating, &prod.ReviewCount, &prod.LowestPrice30d, &prod.CreatedAt, &prod.DeletedAt, &prod.Version,
+			&prod.Relevance)
+		if err != nil {
+			return err
+		}
+		err = fn(&prod)
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
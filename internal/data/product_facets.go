@@ -0,0 +1,213 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/abner-tech/Test1/internal/validator"
+	"github.com/lib/pq"
+)
+
+// the facets a client can ask for, each one is also a filter dimension
+const (
+	FacetCategory = "category"
+	FacetPrice    = "price"
+	FacetRating   = "rating"
+)
+
+var FacetSafeList = []string{FacetCategory, FacetPrice, FacetRating}
+
+// price buckets used when the client does not send its own
+var DefaultPriceBuckets = []float64{0, 25, 50, 100, 250}
+
+// which facets to count and how to split prices
+type FacetRequest struct {
+	Facets       []string
+	PriceBuckets []float64 //lower bounds of each bucket, ascending
+}
+
+// how many products are in a category
+type CategoryFacet struct {
+	CategoryID int64  `json:"category_id"`
+	Name       string `json:"name"`
+	Slug       string `json:"slug"`
+	Count      int    `json:"count"`
+}
+
+// how many products have a price in [Min, Max), Max is nil for the last bucket
+type PriceFacet struct {
+	Min   float64  `json:"min"`
+	Max   *float64 `json:"max"`
+	Count int      `json:"count"`
+}
+
+// how many products are rated at least MinRating stars
+type RatingFacet struct {
+	MinRating int `json:"min_rating"`
+	Count     int `json:"count"`
+}
+
+type ProductFacets struct {
+	Category []*CategoryFacet `json:"category,omitempty"`
+	Price    []*PriceFacet    `json:"price,omitempty"`
+	Rating   []*RatingFacet   `json:"rating,omitempty"`
+}
+
+func ValidateFacetRequest(v *validator.Validator, request FacetRequest) {
+	for _, facet := range request.Facets {
+		v.Check(validator.PermittedValue(facet, FacetSafeList...), "facets", "must only contain category, price or rating")
+	}
+
+	v.Check(len(request.PriceBuckets) > 0, "price_buckets", "must contain at least one value")
+	v.Check(len(request.PriceBuckets) <= 20, "price_buckets", "must not contain more than 20 values")
+	for i, bound := range request.PriceBuckets {
+		v.Check(bound >= 0, "price_buckets", "must not contain negative values")
+		if i > 0 {
+			v.Check(bound > request.PriceBuckets[i-1], "price_buckets", "must be in ascending order")
+		}
+	}
+}
+
+// count the products matching the query for every facet that was asked for.
+// each facet uses every filter except its own so the other choices stay visible
+func (p ProductModel) GetProductFacets(productQuery ProductQuery, request FacetRequest) (*ProductFacets, error) {
+	facets := &ProductFacets{}
+	var err error
+
+	if slices.Contains(request.Facets, FacetCategory) {
+		facets.Category, err = p.categoryFacet(productQuery)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if slices.Contains(request.Facets, FacetPrice) {
+		facets.Price, err = p.priceFacet(productQuery, request.PriceBuckets)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if slices.Contains(request.Facets, FacetRating) {
+		facets.Rating, err = p.ratingFacet(productQuery)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return facets, nil
+}
+
+func (p ProductModel) categoryFacet(productQuery ProductQuery) ([]*CategoryFacet, error) {
+	where, args := productQuery.where(FacetCategory)
+	query := fmt.Sprintf(`
+	SELECT categories.id, categories.name, categories.slug, counts.count
+	FROM (
+		SELECT category_id, COUNT(*) AS count
+		FROM products
+		WHERE %s
+		GROUP BY category_id
+	) AS counts
+	INNER JOIN categories ON categories.id = counts.category_id
+	ORDER BY counts.count DESC, categories.name ASC
+	`, where)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := p.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	facets := []*CategoryFacet{}
+	for rows.Next() {
+		var facet CategoryFacet
+		err := rows.Scan(&facet.CategoryID, &facet.Name, &facet.Slug, &facet.Count)
+		if err != nil {
+			return nil, err
+		}
+		facets = append(facets, &facet)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return facets, nil
+}
+
+func (p ProductModel) priceFacet(productQuery ProductQuery, bounds []float64) ([]*PriceFacet, error) {
+	where, args := productQuery.where(FacetPrice)
+	//width_bucket gives 1 for the first bucket, 0 means below the lowest bound
+	query := fmt.Sprintf(`
+	SELECT width_bucket(price::float8, $%d::float8[]) AS bucket, COUNT(*)
+	FROM products
+	WHERE %s
+	GROUP BY bucket
+	`, len(args)+1, where)
+	args = append(args, pq.Array(bounds))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := p.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	//every bucket is returned, even the empty ones
+	facets := []*PriceFacet{}
+	for i, bound := range bounds {
+		facet := &PriceFacet{Min: bound}
+		if i+1 < len(bounds) {
+			facet.Max = &bounds[i+1]
+		}
+		facets = append(facets, facet)
+	}
+
+	for rows.Next() {
+		var bucket, count int
+		err := rows.Scan(&bucket, &count)
+		if err != nil {
+			return nil, err
+		}
+		if bucket >= 1 && bucket <= len(facets) {
+			facets[bucket-1].Count = count
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return facets, nil
+}
+
+// products rated 4 stars & up, 3 stars & up and so on
+func (p ProductModel) ratingFacet(productQuery ProductQuery) ([]*RatingFacet, error) {
+	where, args := productQuery.where(FacetRating)
+	query := fmt.Sprintf(`
+	SELECT
+		COUNT(*) FILTER (WHERE average_rating >= 4),
+		COUNT(*) FILTER (WHERE average_rating >= 3),
+		COUNT(*) FILTER (WHERE average_rating >= 2),
+		COUNT(*) FILTER (WHERE average_rating >= 1)
+	FROM products
+	WHERE %s
+	`, where)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	facets := []*RatingFacet{{MinRating: 4}, {MinRating: 3}, {MinRating: 2}, {MinRating: 1}}
+	err := p.DB.QueryRowContext(ctx, query, args...).Scan(
+		&facets[0].Count,
+		&facets[1].Count,
+		&facets[2].Count,
+		&facets[3].Count,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return facets, nil
+}
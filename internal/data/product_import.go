@@ -0,0 +1,53 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const (
+	ImportModeAtomic     = "atomic"      //nothing is saved unless every row is valid
+	ImportModeBestEffort = "best_effort" //valid rows are saved, invalid ones are reported
+)
+
+var ImportModeSafeList = []string{ImportModeAtomic, ImportModeBestEffort}
+
+// the most rows accepted in a single import
+const MaxImportRows = 10_000
+
+// a row of an import that the database refused
+type ImportRowError struct {
+	Index int //position in the slice passed to ImportProducts
+	Err   error
+}
+
+func (e *ImportRowError) Error() string {
+	return fmt.Sprintf("row %d: %v", e.Index, e.Err)
+}
+
+func (e *ImportRowError) Unwrap() error {
+	return e.Err
+}
+
+// insert all the products in one transaction, either all of them are saved
+// or none are. A row the database refuses is returned as an *ImportRowError
+func (p ProductModel) ImportProducts(products []*Product) error {
+	//one round trip per row, allow more time than a single insert
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for i, product := range products {
+		err = insertProduct(ctx, tx, product)
+		if err != nil {
+			return &ImportRowError{Index: i, Err: err}
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,86 @@
+package data
+
+import (
+	"fmt"
+	"html"
+	"strings"
+
+	"github.com/abner-tech/Test1/internal/validator"
+)
+
+// the text search configurations a client can pick and the stored
+// search vector column built with each of them
+var searchVectorColumns = map[string]string{
+	"english": "search_english", //stemming, "keyboards" finds "keyboard"
+	"simple":  "search_simple",  //exact words only
+}
+
+const DefaultSearchConfig = "english"
+
+// ts_headline marks the matches with these characters instead of html tags,
+// the text is escaped before they are turned into <mark></mark>
+const (
+	highlightStart = "\x01"
+	highlightStop  = "\x02"
+)
+
+// parts of a product that matched the search as html, the product text is
+// escaped and matches are wrapped in <mark></mark>
+type SearchHighlights struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// escape the highlighted text and mark the matches
+func (h *SearchHighlights) toHTML() {
+	marks := strings.NewReplacer(highlightStart, "<mark>", highlightStop, "</mark>")
+	h.Name = marks.Replace(html.EscapeString(h.Name))
+	h.Description = marks.Replace(html.EscapeString(h.Description))
+}
+
+func ValidateSearch(v *validator.Validator, query ProductQuery) {
+	v.Check(len(query.Search) <= 200, "q", "must not be more than 200 bytes")
+	_, ok := searchVectorColumns[query.SearchConfig]
+	v.Check(ok, "search_config", "must be english or simple")
+}
+
+// the condition matching products against the search, config has been
+// checked against searchVectorColumns so it is safe to put in the query
+func searchCondition(config string, placeholder string) string {
+	return fmt.Sprintf("%s @@ websearch_to_tsquery('%s', %s)", searchVectorColumns[config], config, placeholder)
+}
+
+// the relevance of each product, 0 when there is no search
+func searchRank(config string, placeholder string) string {
+	if placeholder == "" {
+		return "0 AS relevance"
+	}
+	return fmt.Sprintf("ts_rank(%s, websearch_to_tsquery('%s', %s)) AS relevance", searchVectorColumns[config], config, placeholder)
+}
+
+// the relevance and the highlighted name and description of each product,
+// products are not ranked or highlighted when there is no search
+func searchColumns(config string, placeholder string) string {
+	if placeholder == "" {
+		return searchRank(config, placeholder) + ", '', ''"
+	}
+	//the raw text is not html so the matches are marked with
+	//highlightStart and highlightStop (chr(1) and chr(2))
+	query := fmt.Sprintf("websearch_to_tsquery('%s', %s)", config, placeholder)
+	return fmt.Sprintf(`%[1]s,
+		ts_headline('%[3]s', coalesce(name, ''), %[2]s, 'HighlightAll=true, StartSel=' || chr(1) || ', StopSel=' || chr(2)),
+		ts_headline('%[3]s', coalesce(description, ''), %[2]s, 'MaxFragments=2, MaxWords=20, MinWords=5, StartSel=' || chr(1) || ', StopSel=' || chr(2))`,
+		searchRank(config, placeholder), query, config)
+}
+
+// relevance only exists when searching, and it is not a column so it
+// cannot be used as a cursor
+func ValidateSearchSort(v *validator.Validator, query ProductQuery, filters Filters) {
+	for _, key := range filters.sortKeys() {
+		if strings.TrimPrefix(key, "-") != "relevance" {
+			continue
+		}
+		v.Check(query.Search != "", "sort", "relevance can only be used together with q")
+		v.Check(!filters.UseCursor, "sort", "relevance can not be used with cursor paging")
+	}
+}
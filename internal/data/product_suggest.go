@@ -0,0 +1,81 @@
+package data
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/abner-tech/Test1/internal/validator"
+)
+
+const (
+	SuggestionProduct  = "product"
+	SuggestionCategory = "category"
+)
+
+// one autocomplete suggestion, either a product name or a category name
+type Suggestion struct {
+	Type  string  `json:"type"`
+	ID    int64   `json:"id"`
+	Text  string  `json:"text"`
+	Score float32 `json:"score"`
+}
+
+// escapes the characters LIKE treats as wildcards
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func ValidateSuggest(v *validator.Validator, q string, limit int) {
+	v.Check(strings.TrimSpace(q) != "", "q", "must be provided")
+	v.Check(len(q) <= 100, "q", "must not be more than 100 bytes")
+	v.Check(limit >= 1 && limit <= 20, "limit", "must be between 1 and 20")
+}
+
+// product and category names matching what the user typed so far.
+// names starting with q come first, then the closest trigram matches,
+// so small typos still find something
+func (p ProductModel) SuggestProducts(q string, limit int) ([]*Suggestion, error) {
+	query := `
+	SELECT type, id, text, score FROM (
+		SELECT 'product' AS type, id, name AS text,
+			lower(name) LIKE $2 AS prefix, word_similarity($1, lower(name)) AS score
+		FROM products
+		WHERE deleted_at IS NULL AND (lower(name) LIKE $2 OR $1 <% lower(name))
+		UNION ALL
+		SELECT 'category', id, name,
+			lower(name) LIKE $2, word_similarity($1, lower(name))
+		FROM categories
+		WHERE lower(name) LIKE $2 OR $1 <% lower(name)
+	) AS matches
+	ORDER BY prefix DESC, score DESC, length(text) ASC, id ASC
+	LIMIT $3
+	`
+
+	term := strings.ToLower(strings.TrimSpace(q))
+	prefix := likeEscaper.Replace(term) + "%"
+
+	//called on every keystroke so give up quickly
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	rows, err := p.DB.QueryContext(ctx, query, term, prefix, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	suggestions := []*Suggestion{}
+	for rows.Next() {
+		var suggestion Suggestion
+		err := rows.Scan(&suggestion.Type, &suggestion.ID, &suggestion.Text, &suggestion.Score)
+		if err != nil {
+			return nil, err
+		}
+		suggestions = append(suggestions, &suggestion)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return suggestions, nil
+}
@@ -0,0 +1,142 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// a saved copy of a product at one version
+type ProductVersion struct {
+	ProductID   int64         `json:"product_id"`
+	Version     int32         `json:"version"`
+	Name        string        `json:"name"`
+	Description string        `json:"description"`
+	Price       float32       `json:"price"`
+	Category    string        `json:"category"`
+	ImageUrl    string        `json:"image_url"`
+	CreatedAt   time.Time     `json:"created_at"`
+	Changes     []FieldChange `json:"changes"` //what changed compared to the version before
+}
+
+// one field that is different between two versions
+type FieldChange struct {
+	Field string `json:"field"`
+	From  any    `json:"from"`
+	To    any    `json:"to"`
+}
+
+// list the fields that differ between two versions of a product
+func diffProductVersions(previous *ProductVersion, current *ProductVersion) []FieldChange {
+	changes := []FieldChange{}
+	if previous.Name != current.Name {
+		changes = append(changes, FieldChange{"name", previous.Name, current.Name})
+	}
+	if previous.Description != current.Description {
+		changes = append(changes, FieldChange{"description", previous.Description, current.Description})
+	}
+	if previous.Price != current.Price {
+		changes = append(changes, FieldChange{"price", previous.Price, current.Price})
+	}
+	if previous.Category != current.Category {
+		changes = append(changes, FieldChange{"category", previous.Category, current.Category})
+	}
+	if previous.ImageUrl != current.ImageUrl {
+		changes = append(changes, FieldChange{"image_url", previous.ImageUrl, current.ImageUrl})
+	}
+	return changes
+}
+
+// get every saved version of a product, oldest first, each with the
+// changes made since the version before it
+func (p ProductModel) GetProductVersions(pid int64) ([]*ProductVersion, error) {
+	if pid < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+	SELECT product_id, version, name, description, price, category, image_url, created_at
+	FROM product_versions
+	WHERE product_id = $1
+	ORDER BY version ASC
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := p.DB.QueryContext(ctx, query, pid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := []*ProductVersion{}
+	for rows.Next() {
+		var version ProductVersion
+		err := rows.Scan(
+			&version.ProductID,
+			&version.Version,
+			&version.Name,
+			&version.Description,
+			&version.Price,
+			&version.Category,
+			&version.ImageUrl,
+			&version.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		//the first version has nothing to compare against
+		version.Changes = []FieldChange{}
+		if len(versions) > 0 {
+			version.Changes = diffProductVersions(versions[len(versions)-1], &version)
+		}
+		versions = append(versions, &version)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return versions, nil
+}
+
+// get one saved version of a product
+func (p ProductModel) GetProductVersion(pid int64, version int64) (*ProductVersion, error) {
+	if pid < 1 || version < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+	SELECT product_id, version, name, description, price, category, image_url, created_at
+	FROM product_versions
+	WHERE product_id = $1 AND version = $2
+	`
+
+	var productVersion ProductVersion
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := p.DB.QueryRowContext(ctx, query, pid, version).Scan(
+		&productVersion.ProductID,
+		&productVersion.Version,
+		&productVersion.Name,
+		&productVersion.Description,
+		&productVersion.Price,
+		&productVersion.Category,
+		&productVersion.ImageUrl,
+		&productVersion.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &productVersion, nil
+}
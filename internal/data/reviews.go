@@ -5,22 +5,47 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"math"
 	"time"
 
 	"github.com/abner-tech/Test1/internal/validator"
+	"github.com/lib/pq"
 )
 
 type Review struct {
 	ID           int64     `json:"id"`
 	ProductID    int64     `json:"product_id"`
+	UserID       int64     `json:"user_id,omitempty"` //zero for reviews posted before user accounts
 	UserName     string    `json:"user_name"`
 	Rating       int8      `json:"rating"`
 	ReviewText   string    `json:"review_text"`
-	HelpfulCount int8      `json:"helpful_count"`
+	HelpfulCount int32     `json:"helpful_count"`
 	CreatedAt    time.Time `json:"created_at"`
 	Version      int16     `json:"version"`
 }
 
+// the name shown with a review comes from the author's account, older reviews
+// without an account fall back to the user_name they were posted with
+const reviewUserName = `COALESCE((SELECT users.name FROM users WHERE users.id = reviews.user_id), reviews.user_name)`
+
+// reviews of soft deleted products are hidden along with the product
+const reviewOfLiveProduct = `EXISTS (SELECT 1 FROM products WHERE products.id = reviews.product_id AND products.deleted_at IS NULL)`
+
+// how many reviews gave a product a certain number of stars
+type StarCount struct {
+	Stars      int     `json:"stars"`
+	Count      int     `json:"count"`
+	Percentage float64 `json:"percentage"`
+}
+
+// breakdown of the ratings a product has received
+type RatingSummary struct {
+	ProductID     int64       `json:"product_id"`
+	TotalReviews  int         `json:"total_reviews"`
+	AverageRating float64     `json:"average_rating"`
+	Stars         []StarCount `json:"stars"` //5 stars first
+}
+
 type ReviewModel struct {
 	DB *sql.DB
 }
@@ -28,14 +53,14 @@ type ReviewModel struct {
 func (r ReviewModel) InsertReview(review *Review, ID int64) error {
 	//query to excecute
 	query := `
-	INSERT INTO reviews (product_id, user_name, rating, review_text)
-	VALUES($1, $2, $3, $4)
+	INSERT INTO reviews (product_id, user_id, user_name, rating, review_text)
+	VALUES($1, $2, $3, $4, $5)
 	RETURNING id, product_id, created_at, version
 	`
 
 	review.ProductID = ID
 
-	args := []any{review.ProductID, review.UserName, review.Rating, review.ReviewText}
+	args := []any{review.ProductID, review.UserID, review.UserName, review.Rating, review.ReviewText}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -50,9 +75,6 @@ func (r ReviewModel) InsertReview(review *Review, ID int64) error {
 
 func ValidateReview(v *validator.Validator, review *Review) {
 	//validate values
-	v.Check(review.UserName != "", "user_name", "must be provided")
-	v.Check(len(review.UserName) <= 25, "username", "must not be more than 25 bytes")
-
 	v.Check(review.Rating >= 0 && review.Rating <= 5, "rating", "must be a number between 1 and 5")
 
 	v.Check(review.ReviewText != "", "review_text", "must be provided")
@@ -66,10 +88,10 @@ func (r ReviewModel) GetReviewByIDS(rid int64, pid int64) (*Review, error) {
 	}
 
 	//query
-	query := `SELECT id, product_id, user_name, rating, review_text, helpful_count, created_at, version
+	query := fmt.Sprintf(`SELECT id, product_id, COALESCE(user_id, 0), %s, rating, review_text, helpful_count, created_at, version
 	FROM reviews
-	WHERE id = $1 AND product_id = $2
-	`
+	WHERE id = $1 AND product_id = $2 AND %s
+	`, reviewUserName, reviewOfLiveProduct)
 	var review Review
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -78,6 +100,7 @@ func (r ReviewModel) GetReviewByIDS(rid int64, pid int64) (*Review, error) {
 	err := r.DB.QueryRowContext(ctx, query, rid, pid).Scan(
 		&review.ID,
 		&review.ProductID,
+		&review.UserID,
 		&review.UserName,
 		&review.Rating,
 		&review.ReviewText,
@@ -98,16 +121,27 @@ func (r ReviewModel) GetReviewByIDS(rid int64, pid int64) (*Review, error) {
 }
 
 func (r ReviewModel) UpdateReview(review *Review) error {
+	//only update if the version has not changed since we read the review
 	query := `
 	UPDATE reviews
 	SET rating = $1, review_text=$2, version=version+1
-	WHERE id=$3 AND product_id=$4
+	WHERE id=$3 AND product_id=$4 AND version=$5
 	RETURNING version
 	`
-	args := []any{review.Rating, review.ReviewText, review.ID, review.ProductID}
+	args := []any{review.Rating, review.ReviewText, review.ID, review.ProductID, review.Version}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
-	return r.DB.QueryRowContext(ctx, query, args...).Scan(&review.Version)
+
+	err := r.DB.QueryRowContext(ctx, query, args...).Scan(&review.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
 }
 
 func (r ReviewModel) DeleteReview(pid int64, rid int64) error {
@@ -141,30 +175,52 @@ func (r ReviewModel) DeleteReview(pid int64, rid int64) error {
 	return nil
 }
 
-func (r ReviewModel) GetAppReviews(reviewText string, name string, filters Filters, productID int64) ([]*Review, Metadata, error) {
-	// Base query with placeholders for reviewText and name filtering
-	query := fmt.Sprintf(`
-	SELECT COUNT(*) OVER(), id, product_id, user_name, rating, review_text, helpful_count, created_at, version
-	FROM reviews
-	WHERE 
+// the WHERE conditions shared by the review list and export, placeholders
+// are numbered from $1 in the order of the returned args
+func reviewWhere(reviewText string, name string, productID int64) (string, []any) {
+	where := fmt.Sprintf(`
 		(to_tsvector('simple', review_text) @@
 		plainto_tsquery('simple', $1) OR $1 = '')
 	AND 
-		(to_tsvector('simple', user_name)
+		(to_tsvector('simple', %s)
 		@@ plainto_tsquery('simple', $2) OR $2 = '')
-	`)
+	`, reviewUserName)
+
+	where += "AND " + reviewOfLiveProduct + " "
 
 	// Add an additional condition if productID is non-zero
-	args := []interface{}{reviewText, name}
+	args := []any{reviewText, name}
 	if productID != 0 {
-		query += "AND product_id = $3 "
+		where += "AND product_id = $3 "
 		args = append(args, productID)
 	}
+	return where, args
+}
+
+func (r ReviewModel) GetAppReviews(reviewText string, name string, filters Filters, productID int64) ([]*Review, Metadata, error) {
+	// Base query with placeholders for reviewText and name filtering
+	where, args := reviewWhere(reviewText, name, productID)
+	query := fmt.Sprintf(`
+	SELECT COUNT(*) OVER(), %s, id, product_id, COALESCE(user_id, 0), %s, rating, %s, helpful_count, created_at, version
+	FROM reviews
+	WHERE %s
+	`, filters.cursorColumn(),
+		//only the text columns the client asked for are read
+		fieldColumn(filters.Fields, "user_name", reviewUserName, "''"),
+		fieldColumn(filters.Fields, "review_text", "review_text", "''"),
+		where)
+
+	// continue after the cursor when paging with cursors
+	cursorCondition, cursorArgs, err := filters.cursorCondition(len(args) + 1)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	query += cursorCondition + " "
+	args = append(args, cursorArgs...)
 
 	// Add ordering and pagination
-	query += fmt.Sprintf("ORDER BY %s %s, id ASC LIMIT $%d OFFSET $%d",
-		filters.sortColumn(),
-		filters.sortDirection(),
+	query += fmt.Sprintf("ORDER BY %s LIMIT $%d OFFSET $%d",
+		filters.orderBy(),
 		len(args)+1, // LIMIT placeholder index
 		len(args)+2, // OFFSET placeholder index
 	)
@@ -183,12 +239,16 @@ func (r ReviewModel) GetAppReviews(reviewText string, name string, filters Filte
 
 	totalRecords := 0
 	reviews := []*Review{}
+	keys := []cursorKey{}
 
 	for rows.Next() {
 		var rev Review
+		var key cursorKey
 		err := rows.Scan(&totalRecords,
+			&key.Value,
 			&rev.ID,
 			&rev.ProductID,
+			&rev.UserID,
 			&rev.UserName,
 			&rev.Rating,
 			&rev.ReviewText,
@@ -199,32 +259,104 @@ func (r ReviewModel) GetAppReviews(reviewText string, name string, filters Filte
 		if err != nil {
 			return nil, Metadata{}, err
 		}
+		key.ID = rev.ID
 		reviews = append(reviews, &rev)
+		keys = append(keys, key)
 	}
 	err = rows.Err()
 	if err != nil {
 		return nil, Metadata{}, err
 	}
 
+	if filters.UseCursor {
+		reviews, metadata := cursorPage(filters, reviews, keys)
+		return reviews, metadata, nil
+	}
+
 	metadata := calculateMetaData(totalRecords, filters.Page, filters.PageSize)
 	return reviews, metadata, nil
 }
 
-func (r ReviewModel) GetAndIncrementHelpfulCount(rid int64) (*Review, error) {
-	// First, retrieve the review and increment the helpful count if it exists.
-	query := `
-		UPDATE reviews
-		SET helpful_count = helpful_count + 1
-		WHERE id = $1
-		RETURNING id, product_id, user_name, rating, review_text, helpful_count, created_at, version
-	`
-	var review Review
+// mark a review as helpful, a user voting again changes nothing
+func (r ReviewModel) AddHelpfulVote(rid int64, userID int64) (*Review, error) {
+	return r.changeHelpfulVote(rid, userID, true)
+}
+
+// take back a helpful vote, nothing changes if the user never voted
+func (r ReviewModel) RemoveHelpfulVote(rid int64, userID int64) (*Review, error) {
+	return r.changeHelpfulVote(rid, userID, false)
+}
+
+// add or remove a vote and keep helpful_count in step with the votes table,
+// both happen in one transaction
+func (r ReviewModel) changeHelpfulVote(rid int64, userID int64, add bool) (*Review, error) {
+	if rid < 1 {
+		return nil, ErrRecordNotFound
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := r.DB.QueryRowContext(ctx, query, rid).Scan(
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	//does nothing once the transaction has been committed
+	defer tx.Rollback()
+
+	//lock the review so concurrent votes are counted one after the other
+	var id int64
+	err = tx.QueryRowContext(ctx, `SELECT id FROM reviews WHERE id = $1 AND `+reviewOfLiveProduct+` FOR UPDATE`, rid).Scan(&id)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	query := `
+	INSERT INTO review_helpful_votes (review_id, user_id)
+	VALUES ($1, $2)
+	ON CONFLICT DO NOTHING
+	`
+	delta := 1
+	if !add {
+		query = `
+		DELETE FROM review_helpful_votes
+		WHERE review_id = $1 AND user_id = $2
+		`
+		delta = -1
+	}
+
+	result, err := tx.ExecContext(ctx, query, rid, userID)
+	if err != nil {
+		return nil, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	//only change the count if a vote was really added or removed
+	if rowsAffected > 0 {
+		_, err = tx.ExecContext(ctx, `UPDATE reviews SET helpful_count = helpful_count + $2 WHERE id = $1`, rid, delta)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	query = fmt.Sprintf(`
+	SELECT id, product_id, COALESCE(user_id, 0), %s, rating, review_text, helpful_count, created_at, version
+	FROM reviews
+	WHERE id = $1
+	`, reviewUserName)
+	var review Review
+	err = tx.QueryRowContext(ctx, query, rid).Scan(
 		&review.ID,
 		&review.ProductID,
+		&review.UserID,
 		&review.UserName,
 		&review.Rating,
 		&review.ReviewText,
@@ -233,12 +365,171 @@ func (r ReviewModel) GetAndIncrementHelpfulCount(rid int64) (*Review, error) {
 		&review.Version,
 	)
 	if err != nil {
-		switch {
-		case errors.Is(err, sql.ErrNoRows):
-			return nil, ErrRecordNotFound
-		default:
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return &review, nil
+}
+
+// count the 1 to 5 star reviews of a product and work out the
+// average and the percentage for each star
+func (r ReviewModel) GetRatingSummary(pid int64) (*RatingSummary, error) {
+	summaries, err := r.GetRatingSummaries([]int64{pid})
+	if err != nil {
+		return nil, err
+	}
+	return summaries[pid], nil
+}
+
+// the rating summaries of several products in one query, every product
+// asked for gets a summary (all zeros when it has no reviews)
+func (r ReviewModel) GetRatingSummaries(pids []int64) (map[int64]*RatingSummary, error) {
+	query := `
+	SELECT product_id, rating, COUNT(*)
+	FROM reviews
+	WHERE product_id = ANY($1) AND rating BETWEEN 1 AND 5
+	GROUP BY product_id, rating
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := r.DB.QueryContext(ctx, query, pq.Array(pids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	//index 0 holds 1 star reviews and so on
+	counts := make(map[int64]*[5]int, len(pids))
+	for _, pid := range pids {
+		counts[pid] = &[5]int{}
+	}
+	for rows.Next() {
+		var pid int64
+		var rating, count int
+		err := rows.Scan(&pid, &rating, &count)
+		if err != nil {
 			return nil, err
 		}
+		counts[pid][rating-1] = count
 	}
-	return &review, nil
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	summaries := make(map[int64]*RatingSummary, len(pids))
+	for pid, productCounts := range counts {
+		summaries[pid] = newRatingSummary(pid, *productCounts)
+	}
+	return summaries, nil
+}
+
+// work out the totals and percentages from the number of reviews per star
+func newRatingSummary(pid int64, counts [5]int) *RatingSummary {
+	summary := &RatingSummary{
+		ProductID: pid,
+		Stars:     []StarCount{},
+	}
+	total := 0
+	for i, count := range counts {
+		total += count
+		summary.AverageRating += float64((i + 1) * count)
+	}
+	summary.TotalReviews = total
+
+	for stars := 5; stars >= 1; stars-- {
+		starCount := StarCount{Stars: stars, Count: counts[stars-1]}
+		if total > 0 {
+			starCount.Percentage = math.Round(float64(starCount.Count)/float64(total)*10000) / 100
+		}
+		summary.Stars = append(summary.Stars, starCount)
+	}
+	if total > 0 {
+		summary.AverageRating = math.Round(summary.AverageRating/float64(total)*100) / 100
+	}
+
+	return summary
+}
+
+// the first limit reviews of each product in the order of filters.Sorting,
+// fetched for all the products in one query
+func (r ReviewModel) GetReviewsForProducts(pids []int64, filters Filters, limit int) (map[int64][]*Review, error) {
+	//the ranking happens before the names are looked up so only the
+	//reviews sent back pay for it
+	query := fmt.Sprintf(`
+	SELECT id, product_id, COALESCE(user_id, 0), %s, rating, review_text, helpful_count, created_at, version
+	FROM (
+		SELECT id, product_id, user_id, user_name, rating, review_text, helpful_count, created_at, version,
+			ROW_NUMBER() OVER (PARTITION BY product_id ORDER BY %s) AS position
+		FROM reviews
+		WHERE product_id = ANY($1) AND %s
+	) AS reviews
+	WHERE position <= $2
+	ORDER BY product_id, position
+	`, reviewUserName, filters.orderBy(), reviewOfLiveProduct)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := r.DB.QueryContext(ctx, query, pq.Array(pids), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reviews := make(map[int64][]*Review, len(pids))
+	for _, pid := range pids {
+		reviews[pid] = []*Review{}
+	}
+	for rows.Next() {
+		var rev Review
+		err := rows.Scan(&rev.ID, &rev.ProductID, &rev.UserID, &rev.UserName, &rev.Rating, &rev.ReviewText, &rev.HelpfulCount, &rev.CreatedAt, &rev.Version)
+		if err != nil {
+			return nil, err
+		}
+		reviews[rev.ProductID] = append(reviews[rev.ProductID], &rev)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return reviews, nil
+}
+
+// call fn with every review matching the filters, in the order of
+// filters.Sorting. Rows are read one at a time, paging in filters is ignored
+func (r ReviewModel) ExportReviews(ctx context.Context, reviewText string, name string, productID int64, filters Filters, fn func(*Review) error) error {
+	where, args := reviewWhere(reviewText, name, productID)
+	query := fmt.Sprintf(`
+	SELECT id, product_id, COALESCE(user_id, 0), %s, rating, review_text, helpful_count, created_at, version
+	FROM reviews
+	WHERE %s
+	ORDER BY %s
+	`, reviewUserName, where, filters.orderBy())
+
+	rows, err := r.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rev Review
+		err := rows.Scan(&rev.ID, &rev.ProductID, &rev.UserID, &rev.UserName, &rev.Rating, &rev.ReviewText, &rev.HelpfulCount, &rev.CreatedAt, &rev.Version)
+		if err != nil {
+			return err
+		}
+		err = fn(&rev)
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Err()
 }
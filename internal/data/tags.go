@@ -0,0 +1,125 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/abner-tech/Test1/internal/validator"
+	"github.com/lib/pq"
+)
+
+// how tags given in a filter are matched
+const (
+	TagMatchAny = "any"
+	TagMatchAll = "all"
+)
+
+// a tag and how many products use it
+type TagCount struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// the tags of a product come from product_tags, sorted by name
+const productTags = `ARRAY(
+		SELECT tags.name
+		FROM product_tags
+		INNER JOIN tags ON tags.id = product_tags.tag_id
+		WHERE product_tags.product_id = products.id
+		ORDER BY tags.name
+	)`
+
+// lowercase and trim the tags and drop empty values and duplicates
+func NormalizeTags(tags []string) []string {
+	normalized := []string{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag != "" && !slices.Contains(normalized, tag) {
+			normalized = append(normalized, tag)
+		}
+	}
+	return normalized
+}
+
+func ValidateTags(v *validator.Validator, key string, tags []string) {
+	v.Check(len(tags) <= 20, key, "must not contain more than 20 tags")
+	for _, tag := range tags {
+		v.Check(len(tag) <= 30, key, "must not contain tags longer than 30 bytes")
+		v.Check(SlugRX.MatchString(tag), key, "must only contain lowercase letters, numbers and dashes")
+	}
+}
+
+// make the tags of a product exactly the given list, creating new tags as needed
+func setProductTags(ctx context.Context, tx *sql.Tx, productID int64, tags []string) error {
+	_, err := tx.ExecContext(ctx, `
+	DELETE FROM product_tags
+	WHERE product_id = $1
+	AND tag_id NOT IN (SELECT id FROM tags WHERE name = ANY($2))
+	`, productID, pq.Array(tags))
+	if err != nil {
+		return err
+	}
+
+	if len(tags) == 0 {
+		return nil
+	}
+
+	_, err = tx.ExecContext(ctx, `
+	INSERT INTO tags (name)
+	SELECT unnest($1::text[])
+	ON CONFLICT (name) DO NOTHING
+	`, pq.Array(tags))
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+	INSERT INTO product_tags (product_id, tag_id)
+	SELECT $1, id FROM tags WHERE name = ANY($2)
+	ON CONFLICT DO NOTHING
+	`, productID, pq.Array(tags))
+	return err
+}
+
+type TagModel struct {
+	DB *sql.DB
+}
+
+// get every tag with the number of (not deleted) products using it
+func (t TagModel) GetAllWithCounts() ([]*TagCount, error) {
+	query := `
+	SELECT tags.name, COUNT(products.id)
+	FROM tags
+	LEFT JOIN product_tags ON product_tags.tag_id = tags.id
+	LEFT JOIN products ON products.id = product_tags.product_id AND products.deleted_at IS NULL
+	GROUP BY tags.id
+	ORDER BY COUNT(products.id) DESC, tags.name ASC
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := t.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []*TagCount{}
+	for rows.Next() {
+		var tag TagCount
+		err := rows.Scan(&tag.Name, &tag.Count)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, &tag)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
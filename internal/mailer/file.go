@@ -0,0 +1,45 @@
+package mailer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// FileMailer writes every email to a .eml file in a directory instead of
+// sending it, so the application works without a mail server
+type FileMailer struct {
+	dir    string
+	sender string
+}
+
+func NewFile(dir string, sender string) *FileMailer {
+	return &FileMailer{
+		dir:    dir,
+		sender: sender,
+	}
+}
+
+func (m *FileMailer) Send(recipient string, templateFile string, data any) error {
+	msg, err := render(templateFile, data)
+	if err != nil {
+		return err
+	}
+	body, err := msg.bytes(m.sender, recipient)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(m.dir, 0o755)
+	if err != nil {
+		return err
+	}
+
+	//e.g 20241030T101500.000000000-alice_example.com.eml
+	name := fmt.Sprintf("%s-%s.eml",
+		time.Now().Format("20060102T150405.000000000"),
+		strings.NewReplacer("@", "_", "/", "_").Replace(recipient))
+	return os.WriteFile(filepath.Join(m.dir, name), body, 0o644)
+}
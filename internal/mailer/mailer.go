@@ -0,0 +1,104 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"mime/multipart"
+	"net/textproto"
+	"text/template"
+	"time"
+
+	htmltemplate "html/template"
+)
+
+//go:embed "templates"
+var templateFS embed.FS
+
+// Mailer sends an email built from one of the templates in templates/.
+// there is an SMTP implementation and a file implementation which is
+// useful when no mail server is available
+type Mailer interface {
+	Send(recipient string, templateFile string, data any) error
+}
+
+// the parts of an email after the template has been executed
+type message struct {
+	subject   string
+	plainBody string
+	htmlBody  string
+}
+
+// execute the subject, plainBody and htmlBody templates of a template file
+func render(templateFile string, data any) (*message, error) {
+	tmpl, err := template.New("email").ParseFS(templateFS, "templates/"+templateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	subject := new(bytes.Buffer)
+	err = tmpl.ExecuteTemplate(subject, "subject", data)
+	if err != nil {
+		return nil, err
+	}
+
+	plainBody := new(bytes.Buffer)
+	err = tmpl.ExecuteTemplate(plainBody, "plainBody", data)
+	if err != nil {
+		return nil, err
+	}
+
+	//the html part uses html/template so values are escaped
+	htmlTmpl, err := htmltemplate.New("email").ParseFS(templateFS, "templates/"+templateFile)
+	if err != nil {
+		return nil, err
+	}
+	htmlBody := new(bytes.Buffer)
+	err = htmlTmpl.ExecuteTemplate(htmlBody, "htmlBody", data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &message{
+		subject:   subject.String(),
+		plainBody: plainBody.String(),
+		htmlBody:  htmlBody.String(),
+	}, nil
+}
+
+// build the raw email with a plain text and an html alternative
+func (m *message) bytes(sender string, recipient string) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	writer := multipart.NewWriter(buf)
+
+	fmt.Fprintf(buf, "From: %s\r\n", sender)
+	fmt.Fprintf(buf, "To: %s\r\n", recipient)
+	fmt.Fprintf(buf, "Subject: %s\r\n", m.subject)
+	fmt.Fprintf(buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(buf, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(buf, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", writer.Boundary())
+
+	parts := []struct {
+		contentType string
+		body        string
+	}{
+		{"text/plain; charset=UTF-8", m.plainBody},
+		{"text/html; charset=UTF-8", m.htmlBody},
+	}
+	for _, p := range parts {
+		part, err := writer.CreatePart(textproto.MIMEHeader{"Content-Type": {p.contentType}})
+		if err != nil {
+			return nil, err
+		}
+		_, err = part.Write([]byte(p.body))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := writer.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,47 @@
+package mailer
+
+import (
+	"fmt"
+	"net/smtp"
+	"time"
+)
+
+// SMTPMailer sends emails through an SMTP server
+type SMTPMailer struct {
+	addr   string
+	auth   smtp.Auth
+	sender string
+}
+
+func NewSMTP(host string, port int, username string, password string, sender string) *SMTPMailer {
+	var auth smtp.Auth
+	if username != "" {
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+	return &SMTPMailer{
+		addr:   fmt.Sprintf("%s:%d", host, port),
+		auth:   auth,
+		sender: sender,
+	}
+}
+
+func (m *SMTPMailer) Send(recipient string, templateFile string, data any) error {
+	msg, err := render(templateFile, data)
+	if err != nil {
+		return err
+	}
+	body, err := msg.bytes(m.sender, recipient)
+	if err != nil {
+		return err
+	}
+
+	//try sending the email up to three times before giving up
+	for i := 1; i <= 3; i++ {
+		err = smtp.SendMail(m.addr, m.auth, m.sender, []string{recipient}, body)
+		if err == nil {
+			return nil
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	return err
+}
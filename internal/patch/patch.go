@@ -0,0 +1,450 @@
+// Package patch applies JSON Merge Patch (RFC 7396) and JSON Patch
+// (RFC 6902) documents to json encoded records.
+package patch
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// the Content-Type of each kind of patch
+const (
+	MergePatchType = "application/merge-patch+json"
+	JSONPatchType  = "application/json-patch+json"
+)
+
+var (
+	ErrInvalidOperation = errors.New("invalid operation")
+	ErrPathNotFound     = errors.New("path does not exist")
+	ErrTestFailed       = errors.New("test failed")
+)
+
+// OperationError says which operation of a JSON Patch could not be applied
+type OperationError struct {
+	Index int //position of the operation in the patch, from 0
+	Op    string
+	Path  string
+	Err   error
+}
+
+func (e *OperationError) Error() string {
+	return fmt.Sprintf("operation %d (%s %q): %v", e.Index, e.Op, e.Path, e.Err)
+}
+
+func (e *OperationError) Unwrap() error {
+	return e.Err
+}
+
+// Merge applies a merge patch to doc: members of the patch replace the
+// members of doc, null removes them and objects are merged recursively
+func Merge(doc []byte, patch []byte) ([]byte, error) {
+	target, err := decode(doc)
+	if err != nil {
+		return nil, err
+	}
+	changes, err := decode(patch)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(mergeValue(target, changes))
+}
+
+func mergeValue(target any, patch any) any {
+	changes, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	object, ok := target.(map[string]any)
+	if !ok {
+		object = map[string]any{}
+	}
+	for key, value := range changes {
+		if value == nil {
+			delete(object, key)
+			continue
+		}
+		object[key] = mergeValue(object[key], value)
+	}
+	return object
+}
+
+// Operation is one step of a JSON Patch
+type Operation struct {
+	Op    string          `json:"op"`
+	Path  *string         `json:"path"`
+	From  *string         `json:"from"`
+	Value json.RawMessage `json:"value"` //nil when missing, "null" when null
+}
+
+// Patch is a JSON Patch, its operations are applied in order and all of
+// them must succeed
+type Patch []Operation
+
+// Decode reads a JSON Patch and checks every operation has the members
+// its op needs. A broken operation is returned as an *OperationError
+func Decode(body []byte) (Patch, error) {
+	var p Patch
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(&p)
+	if err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, errors.New("body must only contain a single JSON value")
+	}
+
+	for i, operation := range p {
+		fail := func(message string) error {
+			return &OperationError{Index: i, Op: operation.Op, Path: operation.path(), Err: fmt.Errorf("%w: %s", ErrInvalidOperation, message)}
+		}
+		switch operation.Op {
+		case "add", "remove", "replace", "move", "copy", "test":
+		default:
+			return nil, fail("op must be add, remove, replace, move, copy or test")
+		}
+		if operation.Path == nil {
+			return nil, fail("path must be provided")
+		}
+		_, err := parsePointer(*operation.Path)
+		if err != nil {
+			return nil, fail(err.Error())
+		}
+		switch operation.Op {
+		case "add", "replace", "test":
+			if operation.Value == nil {
+				return nil, fail("value must be provided")
+			}
+		case "move", "copy":
+			if operation.From == nil {
+				return nil, fail("from must be provided")
+			}
+			_, err := parsePointer(*operation.From)
+			if err != nil {
+				return nil, fail(err.Error())
+			}
+		}
+		if operation.Op == "move" && strings.HasPrefix(*operation.Path+"/", *operation.From+"/") && *operation.Path != *operation.From {
+			return nil, fail("a value can not be moved into itself")
+		}
+	}
+	return p, nil
+}
+
+func (o Operation) path() string {
+	if o.Path == nil {
+		return ""
+	}
+	return *o.Path
+}
+
+// Apply runs the operations against doc and returns the patched document.
+// doc is left as it is when an operation fails
+func (p Patch) Apply(doc []byte) ([]byte, error) {
+	target, err := decode(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, operation := range p {
+		target, err = operation.apply(target)
+		if err != nil {
+			return nil, &OperationError{Index: i, Op: operation.Op, Path: operation.path(), Err: err}
+		}
+	}
+	return json.Marshal(target)
+}
+
+// LastOperationOn finds the last operation that changed the top level
+// member of the document (or something inside it), -1 when none did
+func (p Patch) LastOperationOn(member string) int {
+	prefix := "/" + escape(member)
+	touches := func(path *string) bool {
+		return path != nil && (*path == prefix || strings.HasPrefix(*path, prefix+"/") || *path == "")
+	}
+	for i := len(p) - 1; i >= 0; i-- {
+		operation := p[i]
+		if operation.Op == "test" {
+			continue
+		}
+		if touches(operation.Path) || (operation.Op == "move" && touches(operation.From)) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (o Operation) apply(doc any) (any, error) {
+	path, _ := parsePointer(*o.Path)
+	var value any
+	if o.Value != nil {
+		var err error
+		value, err = decode(o.Value)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch o.Op {
+	case "add":
+		return update(doc, path, addTo(value))
+	case "remove":
+		return update(doc, path, removeFrom)
+	case "replace":
+		return update(doc, path, replaceIn(value))
+	case "move", "copy":
+		from, _ := parsePointer(*o.From)
+		moved, err := get(doc, from)
+		if err != nil {
+			return nil, err
+		}
+		if o.Op == "move" {
+			doc, err = update(doc, from, removeFrom)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			moved = deepCopy(moved)
+		}
+		return update(doc, path, addTo(moved))
+	case "test":
+		current, err := get(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !equal(current, value) {
+			return nil, ErrTestFailed
+		}
+		return doc, nil
+	}
+	return nil, ErrInvalidOperation
+}
+
+// change the member key of container, returning the changed container
+type change func(container any, key string) (any, error)
+
+// apply fn to the container the path points into, the root itself is
+// replaced by fn with an empty key
+func update(doc any, path []string, fn change) (any, error) {
+	if len(path) == 0 {
+		return fn(nil, "")
+	}
+	if len(path) == 1 {
+		return fn(doc, path[0])
+	}
+	child, err := member(doc, path[0])
+	if err != nil {
+		return nil, err
+	}
+	child, err = update(child, path[1:], fn)
+	if err != nil {
+		return nil, err
+	}
+	return replaceIn(child)(doc, path[0])
+}
+
+func addTo(value any) change {
+	return func(container any, key string) (any, error) {
+		switch c := container.(type) {
+		case nil:
+			return value, nil
+		case map[string]any:
+			c[key] = value
+			return c, nil
+		case []any:
+			if key == "-" {
+				return append(c, value), nil
+			}
+			i, err := index(key, len(c)+1)
+			if err != nil {
+				return nil, err
+			}
+			c = append(c, nil)
+			copy(c[i+1:], c[i:])
+			c[i] = value
+			return c, nil
+		}
+		return nil, ErrPathNotFound
+	}
+}
+
+func removeFrom(container any, key string) (any, error) {
+	switch c := container.(type) {
+	case nil:
+		return nil, errors.New("the whole document can not be removed")
+	case map[string]any:
+		_, ok := c[key]
+		if !ok {
+			return nil, ErrPathNotFound
+		}
+		delete(c, key)
+		return c, nil
+	case []any:
+		i, err := index(key, len(c))
+		if err != nil {
+			return nil, err
+		}
+		return append(c[:i], c[i+1:]...), nil
+	}
+	return nil, ErrPathNotFound
+}
+
+func replaceIn(value any) change {
+	return func(container any, key string) (any, error) {
+		switch c := container.(type) {
+		case nil:
+			return value, nil
+		case map[string]any:
+			_, ok := c[key]
+			if !ok {
+				return nil, ErrPathNotFound
+			}
+			c[key] = value
+			return c, nil
+		case []any:
+			i, err := index(key, len(c))
+			if err != nil {
+				return nil, err
+			}
+			c[i] = value
+			return c, nil
+		}
+		return nil, ErrPathNotFound
+	}
+}
+
+func get(doc any, path []string) (any, error) {
+	var err error
+	for _, key := range path {
+		doc, err = member(doc, key)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return doc, nil
+}
+
+func member(container any, key string) (any, error) {
+	switch c := container.(type) {
+	case map[string]any:
+		value, ok := c[key]
+		if !ok {
+			return nil, ErrPathNotFound
+		}
+		return value, nil
+	case []any:
+		i, err := index(key, len(c))
+		if err != nil {
+			return nil, err
+		}
+		return c[i], nil
+	}
+	return nil, ErrPathNotFound
+}
+
+// an array index from a pointer, it must be below length
+func index(key string, length int) (int, error) {
+	i, err := strconv.Atoi(key)
+	if err != nil || i < 0 || i >= length || (len(key) > 1 && key[0] == '0') {
+		return 0, ErrPathNotFound
+	}
+	return i, nil
+}
+
+// split a JSON Pointer (RFC 6901) such as /tags/0 into its reference tokens
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, errors.New("path must be empty or start with /")
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+func escape(token string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(token, "~", "~0"), "/", "~1")
+}
+
+// numbers are kept as json.Number so they are not rounded on the way through
+func decode(raw []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var value any
+	err := dec.Decode(&value)
+	if err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, errors.New("must only contain a single JSON value")
+	}
+	return value, nil
+}
+
+func deepCopy(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		c := make(map[string]any, len(v))
+		for key, item := range v {
+			c[key] = deepCopy(item)
+		}
+		return c
+	case []any:
+		c := make([]any, len(v))
+		for i, item := range v {
+			c[i] = deepCopy(item)
+		}
+		return c
+	}
+	return value
+}
+
+// json equality, 1 and 1.0 are the same number
+func equal(a any, b any) bool {
+	switch x := a.(type) {
+	case map[string]any:
+		y, ok := b.(map[string]any)
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for key, value := range x {
+			other, ok := y[key]
+			if !ok || !equal(value, other) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		y, ok := b.([]any)
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !equal(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	case json.Number:
+		y, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		if x == y {
+			return true
+		}
+		xf, xerr := x.Float64()
+		yf, yerr := y.Float64()
+		return xerr == nil && yerr == nil && xf == yf
+	default:
+		return a == b
+	}
+}
@@ -0,0 +1,13 @@
+--citext lets us compare emails without caring about upper/lower case
+CREATE EXTENSION IF NOT EXISTS citext;
+
+--script to create the users table
+CREATE TABLE IF NOT EXISTS users (
+    id bigserial PRIMARY KEY,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    name TEXT NOT NULL, --display name of the user
+    email citext UNIQUE NOT NULL,
+    password_hash bytea NOT NULL, --bcrypt hash, never the plain password
+    activated bool NOT NULL DEFAULT false, --set once the emailed token is redeemed
+    version integer NOT NULL DEFAULT 1
+);
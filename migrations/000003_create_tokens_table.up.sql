@@ -0,0 +1,8 @@
+--script to create the tokens table
+--only the sha-256 hash of a token is stored, the plain token is sent to the user
+CREATE TABLE IF NOT EXISTS tokens (
+    hash bytea PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE, --foreign key
+    expiry TIMESTAMP(0) WITH TIME ZONE NOT NULL,
+    scope TEXT NOT NULL --what the token can be used for e.g activation
+);
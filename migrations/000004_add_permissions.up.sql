@@ -0,0 +1,19 @@
+--script to create the permissions table
+CREATE TABLE IF NOT EXISTS permissions (
+    id bigserial PRIMARY KEY,
+    code text NOT NULL UNIQUE --e.g products:write
+);
+
+--join table, which permissions each user has
+CREATE TABLE IF NOT EXISTS users_permissions (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    permission_id bigint NOT NULL REFERENCES permissions ON DELETE CASCADE,
+    PRIMARY KEY (user_id, permission_id)
+);
+
+INSERT INTO permissions (code)
+VALUES
+    ('products:write'), --create, update and delete products
+    ('reviews:write'), --post reviews
+    ('reviews:moderate') --change or remove any review
+ON CONFLICT (code) DO NOTHING;
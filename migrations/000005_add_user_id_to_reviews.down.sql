@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS reviews_user_id_idx;
+ALTER TABLE reviews DROP COLUMN IF EXISTS user_id;
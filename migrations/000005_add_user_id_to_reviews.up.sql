@@ -0,0 +1,6 @@
+--reviews now belong to a user account, existing reviews keep their
+--free text user_name and have no user_id (only moderators can change them)
+ALTER TABLE reviews
+ADD COLUMN IF NOT EXISTS user_id bigint REFERENCES users ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS reviews_user_id_idx ON reviews (user_id);
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS review_helpful_votes;
+
+--put back the counts from before votes were tracked
+UPDATE reviews SET helpful_count = legacy_helpful_count WHERE legacy_helpful_count IS NOT NULL;
+ALTER TABLE reviews DROP COLUMN IF EXISTS legacy_helpful_count;
@@ -0,0 +1,16 @@
+--script to create the review_helpful_votes table
+--a user can only mark a review as helpful once
+CREATE TABLE IF NOT EXISTS review_helpful_votes (
+    review_id bigint NOT NULL REFERENCES reviews ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (review_id, user_id)
+);
+
+--counts set before votes were tracked have no votes behind them,
+--start every review from zero so the count matches the votes table.
+--the old counts are kept so the down migration can put them back
+ALTER TABLE reviews
+ADD COLUMN IF NOT EXISTS legacy_helpful_count integer;
+
+UPDATE reviews SET legacy_helpful_count = helpful_count, helpful_count = 0;
@@ -0,0 +1,25 @@
+DROP TRIGGER IF EXISTS update_product_rating ON reviews;
+
+--put back the original function and trigger
+CREATE OR REPLACE FUNCTION automatic_average_rating()
+RETURNS TRIGGER AS $$
+BEGIN
+    UPDATE products
+    SET average_rating = (
+        SELECT ROUND(AVG(rating), 2)
+        FROM reviews
+        WHERE product_id = NEW.product_id
+    )
+    WHERE id = NEW.product_id;
+
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER update_product_rating
+AFTER INSERT OR UPDATE OR DELETE ON reviews
+FOR EACH ROW
+EXECUTE FUNCTION automatic_average_rating();
+
+DROP FUNCTION IF EXISTS recalculate_product_rating(bigint);
+ALTER TABLE products DROP COLUMN IF EXISTS review_count;
@@ -0,0 +1,59 @@
+--number of reviews a product has, kept up to date by the trigger below
+ALTER TABLE products
+ADD COLUMN IF NOT EXISTS review_count integer NOT NULL DEFAULT 0;
+
+--recalculate the average rating and review count of one product
+--a product without reviews goes back to 0
+CREATE OR REPLACE FUNCTION recalculate_product_rating(pid bigint)
+RETURNS void AS $$
+BEGIN
+    UPDATE products
+    SET average_rating = COALESCE((
+            SELECT ROUND(AVG(rating), 2)
+            FROM reviews
+            WHERE product_id = pid
+        ), 0),
+        review_count = (
+            SELECT COUNT(*)
+            FROM reviews
+            WHERE product_id = pid
+        )
+    WHERE id = pid;
+END;
+$$ LANGUAGE plpgsql;
+
+--NEW is NULL on DELETE and OLD is NULL on INSERT, so use whichever exists.
+--when a review moves to another product both products are recalculated
+CREATE OR REPLACE FUNCTION automatic_average_rating()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP IN ('INSERT', 'UPDATE') THEN
+        PERFORM recalculate_product_rating(NEW.product_id);
+    END IF;
+
+    IF TG_OP = 'DELETE' OR (TG_OP = 'UPDATE' AND OLD.product_id <> NEW.product_id) THEN
+        PERFORM recalculate_product_rating(OLD.product_id);
+    END IF;
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+--only changes to the rating or product matter (not helpful_count etc)
+DROP TRIGGER IF EXISTS update_product_rating ON reviews;
+CREATE TRIGGER update_product_rating
+AFTER INSERT OR DELETE OR UPDATE OF product_id, rating ON reviews
+FOR EACH ROW
+EXECUTE FUNCTION automatic_average_rating();
+
+--fix the values that are already wrong
+UPDATE products
+SET average_rating = COALESCE(aggregates.average_rating, 0),
+    review_count = aggregates.review_count
+FROM (
+    SELECT products.id, ROUND(AVG(reviews.rating), 2) AS average_rating, COUNT(reviews.id) AS review_count
+    FROM products
+    LEFT JOIN reviews ON reviews.product_id = products.id
+    GROUP BY products.id
+) AS aggregates
+WHERE products.id = aggregates.id;
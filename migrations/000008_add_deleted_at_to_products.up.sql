@@ -0,0 +1,6 @@
+--products are soft deleted, deleted_at is set instead of removing the row
+--rows deleted for longer than the retention period are purged by the api
+ALTER TABLE products
+ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP(0) WITH TIME ZONE;
+
+CREATE INDEX IF NOT EXISTS products_deleted_at_idx ON products (deleted_at) WHERE deleted_at IS NOT NULL;
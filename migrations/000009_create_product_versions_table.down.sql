@@ -0,0 +1,4 @@
+DROP TRIGGER IF EXISTS save_product_version_on_update ON products;
+DROP TRIGGER IF EXISTS save_product_version_on_insert ON products;
+DROP FUNCTION IF EXISTS save_product_version();
+DROP TABLE IF EXISTS product_versions;
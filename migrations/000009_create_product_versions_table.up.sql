@@ -0,0 +1,42 @@
+--script to create the product_versions table, a copy of every version of a product
+CREATE TABLE IF NOT EXISTS product_versions (
+    product_id bigint NOT NULL REFERENCES products ON DELETE CASCADE,
+    version integer NOT NULL,
+    name VARCHAR(255),
+    description TEXT,
+    price DECIMAL(10,2),
+    category VARCHAR(255),
+    image_url VARCHAR(255),
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(), --when this version was saved
+    PRIMARY KEY (product_id, version)
+);
+
+--save a copy of the product every time a new version is written,
+--rating changes made by the reviews trigger do not change the version
+CREATE OR REPLACE FUNCTION save_product_version()
+RETURNS TRIGGER AS $$
+BEGIN
+    INSERT INTO product_versions (product_id, version, name, description, price, category, image_url)
+    VALUES (NEW.id, NEW.version, NEW.name, NEW.description, NEW.price, NEW.category, NEW.image_url)
+    ON CONFLICT (product_id, version) DO NOTHING;
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE TRIGGER save_product_version_on_insert
+AFTER INSERT ON products
+FOR EACH ROW
+EXECUTE FUNCTION save_product_version();
+
+CREATE OR REPLACE TRIGGER save_product_version_on_update
+AFTER UPDATE ON products
+FOR EACH ROW
+WHEN (OLD.version IS DISTINCT FROM NEW.version)
+EXECUTE FUNCTION save_product_version();
+
+--the current state of existing products is the first version we know of
+INSERT INTO product_versions (product_id, version, name, description, price, category, image_url)
+SELECT id, version, name, description, price, category, image_url
+FROM products
+ON CONFLICT (product_id, version) DO NOTHING;
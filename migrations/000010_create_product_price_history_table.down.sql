@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS record_product_price_change ON products;
+DROP FUNCTION IF EXISTS record_price_change();
+DROP TABLE IF EXISTS product_price_history;
@@ -0,0 +1,28 @@
+--script to create the product_price_history table
+CREATE TABLE IF NOT EXISTS product_price_history (
+    id bigserial PRIMARY KEY,
+    product_id bigint NOT NULL REFERENCES products ON DELETE CASCADE,
+    old_price DECIMAL(10,2),
+    new_price DECIMAL(10,2),
+    changed_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS product_price_history_product_id_changed_at_idx
+ON product_price_history (product_id, changed_at);
+
+--record the old and new price every time the price of a product changes
+CREATE OR REPLACE FUNCTION record_price_change()
+RETURNS TRIGGER AS $$
+BEGIN
+    INSERT INTO product_price_history (product_id, old_price, new_price)
+    VALUES (NEW.id, OLD.price, NEW.price);
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE TRIGGER record_product_price_change
+AFTER UPDATE OF price ON products
+FOR EACH ROW
+WHEN (OLD.price IS DISTINCT FROM NEW.price)
+EXECUTE FUNCTION record_price_change();
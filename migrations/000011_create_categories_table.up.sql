@@ -0,0 +1,35 @@
+--script to create the categories table, a category can have a parent category
+CREATE TABLE IF NOT EXISTS categories (
+    id bigserial PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    slug citext UNIQUE NOT NULL, --e.g home-and-garden
+    parent_id bigint REFERENCES categories ON DELETE RESTRICT,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    version integer NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS categories_parent_id_idx ON categories (parent_id);
+
+--products point at a category, products.category keeps a copy of its name
+ALTER TABLE products
+ADD COLUMN IF NOT EXISTS category_id bigint REFERENCES categories ON DELETE RESTRICT;
+
+CREATE INDEX IF NOT EXISTS products_category_id_idx ON products (category_id);
+
+--fold the free text categories into category rows, spellings that only
+--differ by case or punctuation (ELectronics, Electronics) become one category
+INSERT INTO categories (name, slug)
+SELECT DISTINCT ON (slug) initcap(trim(category)), slug
+FROM (
+    SELECT category, trim(BOTH '-' FROM regexp_replace(lower(trim(category)), '[^a-z0-9]+', '-', 'g')) AS slug
+    FROM products
+    WHERE category IS NOT NULL
+) AS existing
+WHERE slug <> ''
+ORDER BY slug, category
+ON CONFLICT (slug) DO NOTHING;
+
+UPDATE products
+SET category_id = categories.id, category = categories.name
+FROM categories
+WHERE categories.slug = trim(BOTH '-' FROM regexp_replace(lower(trim(products.category)), '[^a-z0-9]+', '-', 'g'));
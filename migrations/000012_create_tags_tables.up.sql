@@ -0,0 +1,14 @@
+--script to create the tags table, names are stored in lowercase
+CREATE TABLE IF NOT EXISTS tags (
+    id bigserial PRIMARY KEY,
+    name text UNIQUE NOT NULL --e.g wireless, gift-idea
+);
+
+--join table, which tags each product has
+CREATE TABLE IF NOT EXISTS product_tags (
+    product_id bigint NOT NULL REFERENCES products ON DELETE CASCADE,
+    tag_id bigint NOT NULL REFERENCES tags ON DELETE CASCADE,
+    PRIMARY KEY (product_id, tag_id)
+);
+
+CREATE INDEX IF NOT EXISTS product_tags_tag_id_idx ON product_tags (tag_id);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS products_search_english_idx;
+DROP INDEX IF EXISTS products_search_simple_idx;
+ALTER TABLE products DROP COLUMN IF EXISTS search_english;
+ALTER TABLE products DROP COLUMN IF EXISTS search_simple;
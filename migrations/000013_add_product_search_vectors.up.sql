@@ -0,0 +1,19 @@
+--stored search vectors so full text search does not rebuild them for every row.
+--name matters most (A), then category (B), then description (C).
+--one column per text search configuration a client can choose
+ALTER TABLE products
+ADD COLUMN IF NOT EXISTS search_simple tsvector GENERATED ALWAYS AS (
+    setweight(to_tsvector('simple', coalesce(name, '')), 'A') ||
+    setweight(to_tsvector('simple', coalesce(category, '')), 'B') ||
+    setweight(to_tsvector('simple', coalesce(description, '')), 'C')
+) STORED;
+
+ALTER TABLE products
+ADD COLUMN IF NOT EXISTS search_english tsvector GENERATED ALWAYS AS (
+    setweight(to_tsvector('english', coalesce(name, '')), 'A') ||
+    setweight(to_tsvector('english', coalesce(category, '')), 'B') ||
+    setweight(to_tsvector('english', coalesce(description, '')), 'C')
+) STORED;
+
+CREATE INDEX IF NOT EXISTS products_search_simple_idx ON products USING GIN (search_simple);
+CREATE INDEX IF NOT EXISTS products_search_english_idx ON products USING GIN (search_english);
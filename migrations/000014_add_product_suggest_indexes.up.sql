@@ -0,0 +1,6 @@
+--trigram matching lets autocomplete find "keyboard" from "keybord".
+--the indexes serve both the prefix LIKE and the word similarity (<%) lookups
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX IF NOT EXISTS products_name_trgm_idx ON products USING GIN (lower(name) gin_trgm_ops) WHERE deleted_at IS NULL;
+CREATE INDEX IF NOT EXISTS categories_name_trgm_idx ON categories USING GIN (lower(name) gin_trgm_ops);